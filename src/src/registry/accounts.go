@@ -0,0 +1,420 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"encoding/pem"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Account lifecycle: key rotation, recovery and closing.
+//
+// A key is rotated by the account itself, signed with the old key. If the key
+// is lost, the account can be recovered with M of the N recovery keys it
+// registered beforehand with setRecoveryKeys. The recovery keys sign
+// recoveryMessage, which names both the current and the new key, so an
+// approval cannot be replayed once the key has changed.
+//
+// The functions whose schema is Bound are signed over operationMessage, which
+// names the function, its arguments and the nonce of the account. The nonce
+// is incremented by every such call, so a signature can only be used once.
+// Nonces outlive closed accounts, so the signatures of a closed account
+// cannot be replayed against an account opened later under the same name.
+const (
+	recoveryTable = "AccountRecovery"
+	nonceTable    = "AccountNonces"
+)
+
+// columns of the RegisteredUsers table
+const (
+	accountEmailColumn = iota
+	accountPubKeyColumn
+	accountPasswordColumn
+	accountRegistrationDateColumn
+	accountDomainsColumn
+	accountRequestedBidsColumn
+	accountOwnedBidsColumn
+)
+
+func createRecoveryTable(stub *shim.ChaincodeStub) error {
+	return stub.CreateTable(recoveryTable, []*shim.ColumnDefinition{
+		{"userEmail", shim.ColumnDefinition_STRING, true, false},
+		{"Threshold", shim.ColumnDefinition_STRING, false, false},
+		{"RecoveryKeys", shim.ColumnDefinition_STRING, false, false},
+	})
+}
+
+func createNonceTable(stub *shim.ChaincodeStub) error {
+	return stub.CreateTable(nonceTable, []*shim.ColumnDefinition{
+		{"userEmail", shim.ColumnDefinition_STRING, true, false},
+		{"Nonce", shim.ColumnDefinition_UINT64, false, false},
+	})
+}
+
+// getAccountNonce returns the nonce the next Bound call of an account must
+// be signed with
+func getAccountNonce(stub TableReader, userEmail string) (uint64, error) {
+	row, err := stub.GetRow(nonceTable, stringKey(userEmail))
+	if err != nil {
+		return 0, err
+	}
+	if len(row.Columns) == 0 {
+		return 0, nil
+	}
+	return row.Columns[1].GetUint64(), nil
+}
+
+// useAccountNonce moves an account on to its next nonce
+func useAccountNonce(stub *shim.ChaincodeStub, userEmail string) error {
+	nonce, err := getAccountNonce(stub, userEmail)
+	if err != nil {
+		return err
+	}
+	row := shim.Row{Columns: []*shim.Column{stringColumn(userEmail), amountColumn(nonce + 1)}}
+	if nonce == 0 {
+		_, err = stub.InsertRow(nonceTable, row)
+	} else {
+		_, err = stub.ReplaceRow(nonceTable, row)
+	}
+	if err != nil {
+		return newError(CodeInternal, "Error updating account nonce: %s", err)
+	}
+	return nil
+}
+
+// operationMessage is what the key of an account signs to call a Bound
+// function: the function, the account, its nonce and the arguments that
+// follow the signature, joined with ':'
+func operationMessage(function string, userEmail string, nonce uint64, params []string) []byte {
+	fields := append([]string{function, userEmail, strconv.FormatUint(nonce, 10)}, params...)
+	return []byte(strings.Join(fields, ":"))
+}
+
+// splitList splits one of the comma separated list columns, dropping the
+// empty entries left behind by appending to an empty list
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func getAccountRow(stub *shim.ChaincodeStub, userEmail string) (shim.Row, error) {
+	row, err := stub.GetRow("RegisteredUsers", []shim.Column{{Value: &shim.Column_String_{String_: userEmail}}})
+	if err != nil {
+		return row, err
+	}
+	if len(row.Columns) == 0 {
+		return row, newError(CodeNotFound, "Account %s does not exist", userEmail)
+	}
+	return row, nil
+}
+
+// replaceAccountColumn rewrites an account row with one column changed
+func replaceAccountColumn(stub *shim.ChaincodeStub, row shim.Row, column int, value string) error {
+	return replaceAccountColumns(stub, row, map[int]string{column: value})
+}
+
+// replaceAccountColumns rewrites an account row with the given columns changed
+func replaceAccountColumns(stub *shim.ChaincodeStub, row shim.Row, values map[int]string) error {
+	columns := append([]*shim.Column{}, row.Columns...)
+	for column, value := range values {
+		columns[column] = &shim.Column{Value: &shim.Column_String_{String_: value}}
+	}
+	_, err := stub.ReplaceRow("RegisteredUsers", shim.Row{Columns: columns})
+	if err != nil {
+		return newError(CodeInternal, "Error updating row for the profile: %s", err)
+	}
+	return nil
+}
+
+// recoveryKeyID identifies a recovery key by its DER encoding, so that the
+// same key counts once whether it is given as text or hex encoded
+func recoveryKeyID(key string) string {
+	pemBytes, _ := decodePublicKey(key)
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return key
+	}
+	return string(block.Bytes)
+}
+
+func recoveryMessage(userEmail string, currentKey string, newKey string) []byte {
+	return []byte(strings.Join([]string{"recover", userEmail, currentKey, newKey}, ":"))
+}
+
+// rotateKey replaces the public key of an account. The call is signed by the
+// old key and newKeySignature, made with the new key over the operationMessage
+// of rotateKey with newPublicKey as its only argument, proves the caller holds
+// the new key.
+//
+// args: account, signature, newPublicKey, newKeySignature, keyAlgorithm
+// (optional, defaults to the algorithm of the old key)
+func (t *DNSChaincode) rotateKey(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	userEmail := args[0]
+	newKey := args[2]
+
+	algorithm, err := getKeyAlgorithm(stub, userEmail)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) > 4 {
+		algorithm = args[4]
+	}
+	nonce, err := getAccountNonce(stub, userEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	valid, err := t.verifySignature(newKey, algorithm, operationMessage("rotateKey", userEmail, nonce, []string{newKey}), args[3])
+	if err != nil {
+		return nil, newError(CodeInvalidArgument, "New public key cannot be used: %s", asChaincodeError(err).Message)
+	}
+	if !valid {
+		return nil, newError(CodeUnauthorized, "Signature by the new key does not match")
+	}
+
+	row, err := getAccountRow(stub, userEmail)
+	if err != nil {
+		return nil, err
+	}
+	err = replaceAccountColumn(stub, row, accountPubKeyColumn, newKey)
+	if err != nil {
+		return nil, err
+	}
+	return nil, setKeyAlgorithm(stub, userEmail, algorithm)
+}
+
+// setRecoveryKeys registers the recovery keys of an account and how many of
+// them must sign a recovery. An empty key list removes recovery. Recovery
+// keys use the default algorithm of their key type.
+//
+// args: account, signature, threshold, recoveryKeys (comma separated, hex)
+func (t *DNSChaincode) setRecoveryKeys(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	userEmail := args[0]
+	threshold, _ := strconv.Atoi(args[2])
+	keys := splitList(args[3])
+
+	key := []shim.Column{{Value: &shim.Column_String_{String_: userEmail}}}
+	if len(keys) == 0 {
+		err := stub.DeleteRow(recoveryTable, key)
+		return nil, err
+	}
+	if threshold < 1 || threshold > len(keys) {
+		return nil, newError(CodeInvalidArgument, "Threshold must be between 1 and %d", len(keys))
+	}
+	seen := make(map[string]bool)
+	for i, recoveryKey := range keys {
+		pubByte, err := decodePublicKey(recoveryKey)
+		if err == nil {
+			_, err = t.parsePublicKey(pubByte, "")
+		}
+		if err != nil {
+			return nil, newError(CodeInvalidArgument, "Recovery key %d cannot be used: %s", i, asChaincodeError(err).Message)
+		}
+		id := recoveryKeyID(recoveryKey)
+		if seen[id] {
+			return nil, newError(CodeInvalidArgument, "Recovery key %d is listed twice", i)
+		}
+		seen[id] = true
+	}
+
+	row := shim.Row{
+		Columns: []*shim.Column{
+			{Value: &shim.Column_String_{String_: userEmail}},
+			{Value: &shim.Column_String_{String_: strconv.Itoa(threshold)}},
+			{Value: &shim.Column_String_{String_: strings.Join(keys, ",")}},
+		},
+	}
+	existing, err := stub.GetRow(recoveryTable, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing.Columns) == 0 {
+		_, err = stub.InsertRow(recoveryTable, row)
+	} else {
+		_, err = stub.ReplaceRow(recoveryTable, row)
+	}
+	if err != nil {
+		return nil, newError(CodeInternal, "Error updating recovery keys: %s", err)
+	}
+	return nil, nil
+}
+
+// recoverAccount replaces the public key of an account that lost its key.
+// signatures holds one entry per registered recovery key, in the order they
+// were registered, each either empty or a signature over recoveryMessage.
+//
+// args: account, newPublicKey, signatures (comma separated, hex),
+// keyAlgorithm (optional, defaults to the algorithm of the lost key)
+func (t *DNSChaincode) recoverAccount(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	userEmail := args[0]
+	newKey := args[1]
+	signatures := strings.Split(args[2], ",")
+
+	algorithm, err := getKeyAlgorithm(stub, userEmail)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) > 3 {
+		algorithm = args[3]
+	}
+	pubByte, _ := decodePublicKey(newKey)
+	_, err = t.parsePublicKey(pubByte, algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	recoveryRow, err := stub.GetRow(recoveryTable, []shim.Column{{Value: &shim.Column_String_{String_: userEmail}}})
+	if err != nil {
+		return nil, err
+	}
+	if len(recoveryRow.Columns) == 0 {
+		return nil, newError(CodeNotFound, "Account %s has no recovery keys", userEmail)
+	}
+	threshold, err := strconv.Atoi(recoveryRow.Columns[1].GetString_())
+	if err != nil {
+		return nil, err
+	}
+	keys := splitList(recoveryRow.Columns[2].GetString_())
+	if len(signatures) != len(keys) {
+		return nil, newError(CodeInvalidArgument, "Expecting %d signatures, one per recovery key", len(keys))
+	}
+
+	accountRow, err := getAccountRow(stub, userEmail)
+	if err != nil {
+		return nil, err
+	}
+	message := recoveryMessage(userEmail, accountRow.Columns[accountPubKeyColumn].GetString_(), newKey)
+
+	// keys registered before duplicates were refused may be listed twice,
+	// they still approve once
+	approvals := make(map[string]bool)
+	for i, signature := range signatures {
+		if signature == "" {
+			continue
+		}
+		valid, err := t.verifySignature(keys[i], "", message, signature)
+		if err != nil {
+			return nil, err
+		}
+		if !valid {
+			return nil, newError(CodeUnauthorized, "Signature %d does not match its recovery key", i)
+		}
+		approvals[recoveryKeyID(keys[i])] = true
+	}
+	if len(approvals) < threshold {
+		return nil, newError(CodeUnauthorized, "Recovery needs %d signatures, got %d", threshold, len(approvals))
+	}
+
+	err = replaceAccountColumn(stub, accountRow, accountPubKeyColumn, newKey)
+	if err != nil {
+		return nil, err
+	}
+	return nil, setKeyAlgorithm(stub, userEmail, algorithm)
+}
+
+// closeAccount deletes an account. The account must not own any domain, be
+// part of an open transfer request, belong to an organisation, be an
+// administrator, have funds in escrow, or have a bid on or a name to claim
+// from an auction. The grants the account holds on other domains are
+// dropped.
+//
+// args: account, signature
+func (t *DNSChaincode) closeAccount(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	userEmail := args[0]
+
+	row, err := getAccountRow(stub, userEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	// The domain list is not pruned on transfer, so check who owns them now
+	for _, domainName := range splitList(row.Columns[accountDomainsColumn].GetString_()) {
+		domainRow, err := stub.GetRow("NameToIP", []shim.Column{{Value: &shim.Column_String_{String_: domainName}}})
+		if err != nil {
+			return nil, err
+		}
+		if len(domainRow.Columns) != 0 && domainRow.Columns[2].GetString_() == userEmail {
+			return nil, newError(CodeConflict, "Account still owns %s. Transfer it before closing the account.", domainName)
+		}
+	}
+
+	// a pending transfer keeps its request open until it completes or is
+	// cancelled, so this also holds back both sides of a pending transfer
+	bids := append(splitList(row.Columns[accountRequestedBidsColumn].GetString_()),
+		splitList(row.Columns[accountOwnedBidsColumn].GetString_())...)
+	for _, requestID := range bids {
+		requestRow, err := stub.GetRow("TransferRequests", []shim.Column{{Value: &shim.Column_String_{String_: requestID}}})
+		if err != nil {
+			return nil, err
+		}
+		if len(requestRow.Columns) != 0 && requestRow.Columns[4].GetString_() == bidStatusOpen {
+			return nil, newError(CodeConflict, "Transfer request %s is still open", requestID)
+		}
+	}
+
+	err = checkNotOrganizationMember(stub, userEmail)
+	if err != nil {
+		return nil, err
+	}
+	err = checkNotAdministrator(stub, userEmail)
+	if err != nil {
+		return nil, err
+	}
+	err = checkNoEscrow(stub, userEmail)
+	if err != nil {
+		return nil, err
+	}
+	err = checkNoAuctionStake(stub, userEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	err = clearGrantsTo(stub, userEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	key := []shim.Column{{Value: &shim.Column_String_{String_: userEmail}}}
+	err = stub.DeleteRow("RegisteredUsers", key)
+	if err != nil {
+		return nil, err
+	}
+	err = stub.DeleteRow(recoveryTable, key)
+	if err != nil {
+		return nil, err
+	}
+	err = stub.DeleteRow(keyAlgorithmTable, key)
+	if err != nil {
+		return nil, err
+	}
+	err = addStat(stub, statsKindTotal, statsAccounts, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Closed account " + userEmail)
+	return nil, nil
+}
@@ -0,0 +1,187 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// recoveryKey generates a key for name, kept in r.keys, and returns its PEM
+// encoded public key
+func (r *testRegistry) recoveryKey(name string) string {
+	return r.accountArgs(name)[2]
+}
+
+func TestRecoveryKeysMustBeDistinct(t *testing.T) {
+	r := newTestRegistry(t)
+	r.createAccount("alice")
+	key := r.recoveryKey("recovery1")
+	other := r.recoveryKey("recovery2")
+
+	for _, keys := range []string{
+		key + "," + key,
+		key + "," + other + "," + hex.EncodeToString([]byte(key)),
+	} {
+		if code := r.invoke("setRecoveryKeys", "alice", "2", keys); code != CodeInvalidArgument {
+			t.Fatalf("Duplicate recovery keys: expected %s, got %q", CodeInvalidArgument, code)
+		}
+	}
+	r.mustInvoke("setRecoveryKeys", "alice", "2", key+","+other)
+}
+
+func TestCloseAdministratorAccount(t *testing.T) {
+	r := newTestRegistry(t)
+	r.createAccount("alice")
+	r.mustInvoke("addAdministrator", testAdmin, "alice")
+	if code := r.invoke("closeAccount", "alice"); code != CodeConflict {
+		t.Fatalf("Closing an administrator: expected %s, got %q", CodeConflict, code)
+	}
+	r.mustInvoke("removeAdministrator", testAdmin, "alice")
+	r.mustInvoke("closeAccount", "alice")
+
+	// an account opened again under the name is not an administrator
+	r.createAccount("alice")
+	if code := r.invoke("addAdministrator", "alice", "bob"); code != CodeUnauthorized {
+		t.Fatalf("Administrator call by a reopened account: expected %s, got %q", CodeUnauthorized, code)
+	}
+}
+
+func (r *testRegistry) nonce(userEmail string) uint64 {
+	payload, err := r.peer.Query("getNonce", []string{userEmail}, r.now)
+	if err != nil {
+		r.t.Fatalf("Error reading nonce of %s: %s", userEmail, err)
+	}
+	nonce, _ := strconv.ParseUint(string(payload), 10, 64)
+	return nonce
+}
+
+// openAccount creates an account for userEmail and returns its public key
+func (r *testRegistry) openAccount(userEmail string) string {
+	args := r.accountArgs(userEmail)
+	if _, err := r.peer.Invoke("createAccount", args, r.now); err != nil {
+		r.t.Fatalf("Error creating account %s: %s", userEmail, err)
+	}
+	return args[2]
+}
+
+func TestRotateKey(t *testing.T) {
+	r := newTestRegistry(t)
+	r.createAccount("alice")
+	oldKey := r.keys["alice"]
+	newKey := r.recoveryKey("alice-new")
+	message := operationMessage("rotateKey", "alice", r.nonce("alice"), []string{newKey})
+
+	// the new key must sign the rotation
+	wrong := hex.EncodeToString(ed25519.Sign(oldKey, message))
+	if code := r.invoke("rotateKey", "alice", newKey, wrong); code != CodeUnauthorized {
+		t.Fatalf("Rotation not signed by the new key: expected %s, got %q", CodeUnauthorized, code)
+	}
+	rotate := r.signedArgs("rotateKey", "alice", newKey, hex.EncodeToString(ed25519.Sign(r.keys["alice-new"], message)))
+	if code := r.invokeArgs("rotateKey", rotate); code != "" {
+		t.Fatalf("Rotation failed: %s", code)
+	}
+
+	if code := r.invoke("registerDomain", "alice", "example.com", "10.0.0.1", "365"); code != CodeUnauthorized {
+		t.Fatalf("Call signed by the old key: expected %s, got %q", CodeUnauthorized, code)
+	}
+	r.keys["alice"] = r.keys["alice-new"]
+	r.mustInvoke("registerDomain", "alice", "example.com", "10.0.0.1", "365")
+	if code := r.invokeArgs("rotateKey", rotate); code != CodeUnauthorized {
+		t.Fatalf("Replayed rotation: expected %s, got %q", CodeUnauthorized, code)
+	}
+}
+
+func TestRecoveryThreshold(t *testing.T) {
+	r := newTestRegistry(t)
+	currentKey := r.openAccount("alice")
+	recoveryKeys := []string{r.recoveryKey("recovery1"), r.recoveryKey("recovery2"), r.recoveryKey("recovery3")}
+	for _, threshold := range []string{"0", "4"} {
+		if code := r.invoke("setRecoveryKeys", "alice", threshold, strings.Join(recoveryKeys, ",")); code != CodeInvalidArgument {
+			t.Fatalf("Threshold %s of 3 keys: expected %s, got %q", threshold, CodeInvalidArgument, code)
+		}
+	}
+	r.mustInvoke("setRecoveryKeys", "alice", "2", strings.Join(recoveryKeys, ","))
+
+	newKey := r.recoveryKey("alice-new")
+	message := recoveryMessage("alice", currentKey, newKey)
+	sign := func(name string) string {
+		return hex.EncodeToString(ed25519.Sign(r.keys[name], message))
+	}
+	for _, c := range []struct {
+		signatures []string
+		code       string
+	}{
+		{[]string{sign("recovery1"), "", ""}, CodeUnauthorized},
+		{[]string{sign("recovery1"), sign("recovery2")}, CodeInvalidArgument},
+		{[]string{sign("recovery1"), sign("recovery3"), ""}, CodeUnauthorized},
+	} {
+		if code := r.invokeArgs("recoverAccount", []string{"alice", newKey, strings.Join(c.signatures, ",")}); code != c.code {
+			t.Fatalf("Recovery with %d signatures: expected %s, got %q", len(c.signatures), c.code, code)
+		}
+	}
+	recovery := []string{"alice", newKey, strings.Join([]string{sign("recovery1"), "", sign("recovery3")}, ",")}
+	if code := r.invokeArgs("recoverAccount", recovery); code != "" {
+		t.Fatalf("Recovery failed: %s", code)
+	}
+
+	if code := r.invoke("registerDomain", "alice", "example.com", "10.0.0.1", "365"); code != CodeUnauthorized {
+		t.Fatalf("Call signed by the lost key: expected %s, got %q", CodeUnauthorized, code)
+	}
+	r.keys["alice"] = r.keys["alice-new"]
+	r.mustInvoke("registerDomain", "alice", "example.com", "10.0.0.1", "365")
+	// the signatures are over the key they replaced
+	if code := r.invokeArgs("recoverAccount", recovery); code != CodeUnauthorized {
+		t.Fatalf("Replayed recovery: expected %s, got %q", CodeUnauthorized, code)
+	}
+}
+
+func TestCloseAccountPreconditions(t *testing.T) {
+	r := newTestRegistry(t)
+	for _, account := range []string{"alice", "bob", "carol"} {
+		r.createAccount(account)
+	}
+
+	r.mustInvoke("registerDomain", "alice", "example.com", "10.0.0.1", "365")
+	if code := r.invoke("closeAccount", "alice"); code != CodeConflict {
+		t.Fatalf("Closing the owner of a domain: expected %s, got %q", CodeConflict, code)
+	}
+	r.mustInvoke("placeBid", "bob", "alice", "example.com", "10")
+	if code := r.invoke("closeAccount", "bob"); code != CodeConflict {
+		t.Fatalf("Closing the buyer of an open bid: expected %s, got %q", CodeConflict, code)
+	}
+	r.mustInvoke("transferDomain", "alice", "example.com", "bob", "10.0.0.2")
+	r.mustInvoke("closeAccount", "alice")
+
+	r.mustInvoke("deposit", testAdmin, "carol", "5")
+	if code := r.invoke("closeAccount", "carol"); code != CodeConflict {
+		t.Fatalf("Closing an account with funds: expected %s, got %q", CodeConflict, code)
+	}
+	r.mustInvoke("withdraw", "carol", "5")
+	r.mustInvoke("createOrganization", "carol", "acme", "", "1")
+	if code := r.invoke("closeAccount", "carol"); code != CodeConflict {
+		t.Fatalf("Closing an organisation member: expected %s, got %q", CodeConflict, code)
+	}
+
+	if code := r.invoke("registerDomain", "alice", "example.org", "10.0.0.3", "365"); code != CodeNotFound {
+		t.Fatalf("Call by a closed account: expected %s, got %q", CodeNotFound, code)
+	}
+}
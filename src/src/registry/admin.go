@@ -0,0 +1,153 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Administrators are ordinary accounts listed in the Administrators table.
+// Functions whose schema sets Admin can only be called by them. The first
+// administrator is bootstrapped by Init, later ones are added and removed by
+// existing administrators.
+const administratorsTable = "Administrators"
+
+func createAdministratorsTable(stub *shim.ChaincodeStub) error {
+	return stub.CreateTable(administratorsTable, []*shim.ColumnDefinition{
+		{"userEmail", shim.ColumnDefinition_STRING, true, false},
+	})
+}
+
+func isAdministrator(stub *shim.ChaincodeStub, userEmail string) (bool, error) {
+	row, err := stub.GetRow(administratorsTable, []shim.Column{{Value: &shim.Column_String_{String_: userEmail}}})
+	if err != nil {
+		return false, newError(CodeInternal, "Error reading administrators: %s", err)
+	}
+	return len(row.Columns) != 0, nil
+}
+
+// checkNotAdministrator is called by closeAccount: an account opened later
+// under the same name would otherwise inherit the administrator rights
+func checkNotAdministrator(stub *shim.ChaincodeStub, userEmail string) error {
+	admin, err := isAdministrator(stub, userEmail)
+	if err != nil {
+		return err
+	}
+	if admin {
+		return newError(CodeConflict, "%s is an administrator. Remove it with removeAdministrator before closing the account.", userEmail)
+	}
+	return nil
+}
+
+// requireAdministrator fails unless userEmail is an administrator
+func requireAdministrator(stub *shim.ChaincodeStub, userEmail string) error {
+	admin, err := isAdministrator(stub, userEmail)
+	if err != nil {
+		return err
+	}
+	if !admin {
+		return newError(CodeUnauthorized, "%s is not an administrator", userEmail)
+	}
+	return nil
+}
+
+// insertAdministrator adds an existing account to the administrators. Adding
+// an administrator twice is not an error.
+func insertAdministrator(stub *shim.ChaincodeStub, userEmail string) error {
+	_, err := getAccountRow(stub, userEmail)
+	if err != nil {
+		return err
+	}
+	_, err = stub.InsertRow(administratorsTable, shim.Row{
+		Columns: []*shim.Column{
+			{Value: &shim.Column_String_{String_: userEmail}},
+		},
+	})
+	if err != nil {
+		return newError(CodeInternal, "Error creating row: %s", err)
+	}
+	return nil
+}
+
+// bootstrapAdministrator makes the account described by createAccount style
+// args an administrator, creating the account first if it does not exist. If
+// it does exist, for instance in state copied from an older chaincode, the
+// public key must match the one on record.
+func (t *DNSChaincode) bootstrapAdministrator(stub *shim.ChaincodeStub, args []string) error {
+	schema, err := lookupSchema(KindInvoke, "createAccount")
+	if err != nil {
+		return err
+	}
+	err = schema.validate(args)
+	if err != nil {
+		return err
+	}
+
+	row, err := getAccountRow(stub, args[0])
+	if err == nil {
+		if row.Columns[accountPubKeyColumn].GetString_() != args[2] {
+			return newError(CodeConflict, "Account %s already exists with a different public key", args[0])
+		}
+	} else if chaincodeErr, ok := err.(*ChaincodeError); ok && chaincodeErr.Code == CodeNotFound {
+		_, err = t.createAccount(stub, args)
+		if err != nil {
+			return err
+		}
+	} else {
+		return err
+	}
+	return insertAdministrator(stub, args[0])
+}
+
+// addAdministrator makes another account an administrator
+//
+// args: account, signature, administrator
+func (t *DNSChaincode) addAdministrator(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	return nil, insertAdministrator(stub, args[2])
+}
+
+// removeAdministrator takes administrator rights away from an account. The
+// last administrator cannot be removed, or nobody could migrate the tables.
+//
+// args: account, signature, administrator
+func (t *DNSChaincode) removeAdministrator(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	admin, err := isAdministrator(stub, args[2])
+	if err != nil {
+		return nil, err
+	}
+	if !admin {
+		return nil, newError(CodeNotFound, "%s is not an administrator", args[2])
+	}
+
+	rowChan, err := stub.GetRows(administratorsTable, []shim.Column{})
+	if err != nil {
+		return nil, newError(CodeInternal, "Error reading administrators: %s", err)
+	}
+	count := 0
+	for range rowChan {
+		count++
+	}
+	if count < 2 {
+		return nil, newError(CodeConflict, "Cannot remove the last administrator")
+	}
+
+	err = stub.DeleteRow(administratorsTable, []shim.Column{{Value: &shim.Column_String_{String_: args[2]}}})
+	if err != nil {
+		return nil, newError(CodeInternal, "Error deleting row: %s", err)
+	}
+	return nil, nil
+}
@@ -0,0 +1,952 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// An administrator can auction a premium name nobody holds, or a name whose
+// registration has expired, which releases it first. Two kinds of auction
+// are supported:
+//
+// English: bids are open and each must beat the high bid by the minimum
+// increment. A bid within the extension window of the end pushes the end out
+// to a full window from the bid, so nobody can snipe the name at the last
+// second.
+//
+// Sealed: during the commit phase bidders record only the commitment
+// sealedBidCommitment(domainName, bidder, amount, salt), hex encoded. During
+// the reveal phase they disclose amount and salt. The highest revealed bid
+// wins and pays the second highest, or the reserve if it was alone (a
+// Vickrey auction). Ties go to the earliest reveal, then to the smaller
+// account name.
+//
+// Every live bid holds its amount in escrow, and settleAuction, which any
+// account can call once bidding is over, captures the price from the winner
+// and releases everything else. The winner then registers the name with
+// registerDomain as usual; nobody else can until it does, or until
+// auctionClaimWindow has passed. After that the name is available again and
+// the price stays paid.
+//
+// Auction times are kept to the second, as Unix times, and are taken from the
+// transaction timestamp. Responses and events give them in RFC 3339.
+const (
+	auctionsTable    = "Auctions"
+	auctionBidsTable = "AuctionBids"
+)
+
+const auctionTimeFormat = time.RFC3339
+
+// auctionClaimWindow is how long the winner of an auction has to register
+// the name, from settlement
+const auctionClaimWindow = 30 * 24 * time.Hour
+
+// maxAuctionSeconds bounds every auction duration, a year
+const maxAuctionSeconds = 365 * 24 * 60 * 60
+
+// Auction kinds
+const (
+	AuctionEnglish = "english"
+	AuctionSealed  = "sealed"
+)
+
+// Auction statuses
+const (
+	auctionOpen      = "open"
+	auctionWon       = "won"
+	auctionUnsold    = "unsold"
+	auctionClaimed   = "claimed"
+	auctionUnclaimed = "unclaimed"
+	auctionCancelled = "cancelled"
+)
+
+// columns of the Auctions table
+const (
+	auctionDomainColumn = iota
+	auctionKindColumn
+	auctionStatusColumn
+	auctionReserveColumn
+	auctionIncrementColumn
+	auctionExtensionColumn
+	auctionCommitEndsColumn
+	auctionEndsColumn
+	auctionHighBidderColumn
+	auctionHighBidColumn
+	auctionWinnerColumn
+	auctionPriceColumn
+	auctionClaimByColumn
+)
+
+// columns of the AuctionBids table, which holds the bids of sealed auctions
+const (
+	sealedDomainColumn = iota
+	sealedBidderColumn
+	sealedCommitmentColumn
+	sealedAmountColumn
+	sealedRevealedColumn
+	sealedRevealedAtColumn
+)
+
+// Auction is the payload returned by getAuction. The bids of a sealed
+// auction are only counted.
+type Auction struct {
+	Domain       string `json:"domainName"`
+	Kind         string `json:"kind"`
+	Status       string `json:"status"`
+	Reserve      uint64 `json:"reserve"`
+	MinIncrement uint64 `json:"minIncrement,omitempty"`
+	Extension    uint64 `json:"extensionSeconds,omitempty"`
+	CommitEnds   string `json:"commitEnds,omitempty"`
+	Ends         string `json:"ends"`
+	HighBidder   string `json:"highBidder,omitempty"`
+	HighBid      uint64 `json:"highBid,omitempty"`
+	Commitments  int    `json:"commitments,omitempty"`
+	Reveals      int    `json:"reveals,omitempty"`
+	Winner       string `json:"winner,omitempty"`
+	Price        uint64 `json:"price,omitempty"`
+	ClaimBy      string `json:"claimBy,omitempty"`
+}
+
+// sealedBid is a revealed bid of a sealed auction
+type sealedBid struct {
+	bidder   string
+	amount   uint64
+	revealed int64
+}
+
+// bidsByRank sorts revealed bids best first: highest amount, then earliest
+// reveal, then smallest account name.
+type bidsByRank []sealedBid
+
+func (b bidsByRank) Len() int      { return len(b) }
+func (b bidsByRank) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b bidsByRank) Less(i, j int) bool {
+	if b[i].amount != b[j].amount {
+		return b[i].amount > b[j].amount
+	}
+	if b[i].revealed != b[j].revealed {
+		return b[i].revealed < b[j].revealed
+	}
+	return b[i].bidder < b[j].bidder
+}
+
+func createAuctionTables(stub *shim.ChaincodeStub) error {
+	err := stub.CreateTable(auctionsTable, []*shim.ColumnDefinition{
+		{"domainName", shim.ColumnDefinition_STRING, true, false},
+		{"Kind", shim.ColumnDefinition_STRING, false, false},
+		{"Status", shim.ColumnDefinition_STRING, false, false},
+		{"Reserve", shim.ColumnDefinition_UINT64, false, false},
+		{"MinIncrement", shim.ColumnDefinition_UINT64, false, false},
+		{"Extension", shim.ColumnDefinition_UINT64, false, false},
+		{"CommitEnds", shim.ColumnDefinition_INT64, false, false},
+		{"Ends", shim.ColumnDefinition_INT64, false, false},
+		{"HighBidder", shim.ColumnDefinition_STRING, false, false},
+		{"HighBid", shim.ColumnDefinition_UINT64, false, false},
+		{"Winner", shim.ColumnDefinition_STRING, false, true},
+		{"Price", shim.ColumnDefinition_UINT64, false, false},
+		{"ClaimBy", shim.ColumnDefinition_INT64, false, false},
+	})
+	if err != nil {
+		return err
+	}
+	return stub.CreateTable(auctionBidsTable, []*shim.ColumnDefinition{
+		{"domainName", shim.ColumnDefinition_STRING, true, false},
+		{"Bidder", shim.ColumnDefinition_STRING, true, false},
+		{"Commitment", shim.ColumnDefinition_BYTES, false, false},
+		{"Amount", shim.ColumnDefinition_UINT64, false, false},
+		{"Revealed", shim.ColumnDefinition_BOOL, false, false},
+		{"RevealedAt", shim.ColumnDefinition_INT64, false, false},
+	})
+}
+
+// sealedBidCommitment is the commitment a sealed bid of amount is recorded
+// with. salt should be random and is kept secret until the reveal.
+func sealedBidCommitment(domainName string, bidder string, amount uint64, salt string) []byte {
+	sum := sha256.Sum256([]byte(domainName + ":" + bidder + ":" + strconv.FormatUint(amount, 10) + ":" + salt))
+	return sum[:]
+}
+
+func getAuctionRow(stub TableReader, domainName string) (shim.Row, error) {
+	row, err := stub.GetRow(auctionsTable, stringKey(domainName))
+	if err != nil {
+		return row, newError(CodeInternal, "Error reading auction of %s: %s", domainName, err)
+	}
+	return row, nil
+}
+
+// getOpenAuctionRow returns the open auction of domainName, which must be of
+// the given kind
+func getOpenAuctionRow(stub *shim.ChaincodeStub, domainName string, kind string) (shim.Row, error) {
+	row, err := getAuctionRow(stub, domainName)
+	if err != nil {
+		return row, err
+	}
+	if len(row.Columns) == 0 || row.Columns[auctionStatusColumn].GetString_() != auctionOpen {
+		return row, newError(CodeNotFound, "%s is not being auctioned", domainName)
+	}
+	if row.Columns[auctionKindColumn].GetString_() != kind {
+		return row, newError(CodeInvalidArgument, "%s is auctioned in a %s auction", domainName, row.Columns[auctionKindColumn].GetString_())
+	}
+	return row, nil
+}
+
+func auctionTime(row shim.Row, column int) time.Time {
+	return time.Unix(row.Columns[column].GetInt64(), 0).UTC()
+}
+
+// formatAuctionTime formats a time column for responses, empty if it is not
+// set
+func formatAuctionTime(row shim.Row, column int) string {
+	if row.Columns[column].GetInt64() == 0 {
+		return ""
+	}
+	return auctionTime(row, column).Format(auctionTimeFormat)
+}
+
+func timeColumn(value time.Time) *shim.Column {
+	return &shim.Column{Value: &shim.Column_Int64{Int64: value.Unix()}}
+}
+
+func amountColumn(value uint64) *shim.Column {
+	return &shim.Column{Value: &shim.Column_Uint64{Uint64: value}}
+}
+
+func stringColumn(value string) *shim.Column {
+	return &shim.Column{Value: &shim.Column_String_{String_: value}}
+}
+
+// newAuctionRow returns an Auctions row for domainName with every other
+// column empty
+func newAuctionRow(domainName string) shim.Row {
+	return shim.Row{
+		Columns: []*shim.Column{
+			stringColumn(domainName),
+			stringColumn(""),
+			stringColumn(""),
+			amountColumn(0),
+			amountColumn(0),
+			amountColumn(0),
+			{Value: &shim.Column_Int64{Int64: 0}},
+			{Value: &shim.Column_Int64{Int64: 0}},
+			stringColumn(""),
+			amountColumn(0),
+			stringColumn(""),
+			amountColumn(0),
+			{Value: &shim.Column_Int64{Int64: 0}},
+		},
+	}
+}
+
+// replaceAuctionColumns rewrites an Auctions row with the given columns
+// changed. The write fails if the row was replaced since it was read.
+func replaceAuctionColumns(stub *shim.ChaincodeStub, row shim.Row, values map[int]*shim.Column) error {
+	columns := make([]*shim.Column, len(row.Columns))
+	copy(columns, row.Columns)
+	for i, value := range values {
+		columns[i] = value
+	}
+	_, err := stub.ReplaceRowIfVersion(auctionsTable, shim.Row{Columns: columns}, row.Version)
+	if err == shim.ErrRowVersionMismatch {
+		return newError(CodeConflict, "The auction of %s changed while it was being updated", row.Columns[auctionDomainColumn].GetString_())
+	}
+	if err != nil {
+		return newError(CodeInternal, "Error writing auction: %s", err)
+	}
+	return nil
+}
+
+// openAuction records a new auction of domainName. A name whose registration
+// has run out is released; a name still registered, or already being
+// auctioned or waiting for its winner, cannot be auctioned.
+func openAuction(stub *shim.ChaincodeStub, domainName string, now time.Time, values map[int]*shim.Column) error {
+	existing, err := getAuctionRow(stub, domainName)
+	if err != nil {
+		return err
+	}
+	if len(existing.Columns) != 0 {
+		switch existing.Columns[auctionStatusColumn].GetString_() {
+		case auctionOpen:
+			return newError(CodeConflict, "%s is already being auctioned", domainName)
+		case auctionWon:
+			if now.Before(auctionTime(existing, auctionClaimByColumn)) {
+				return newError(CodeConflict, "%s was won by %s, who can register it until %s", domainName,
+					existing.Columns[auctionWinnerColumn].GetString_(), formatAuctionTime(existing, auctionClaimByColumn))
+			}
+		}
+	}
+
+	domainRow, err := stub.GetRow("NameToIP", stringKey(domainName))
+	if err != nil {
+		return newError(CodeInternal, "Error reading domain: %s", err)
+	}
+	if len(domainRow.Columns) != 0 {
+		registered, ok := columnDate(domainRow.Columns[domainRegisteredColumn])
+		if !ok || now.Before(expiryTime(registered, domainRow.Columns[domainDurationColumn].GetUint64())) {
+			return newError(CodeConflict, "%s is registered until %s", domainName, formatDate(domainRow.Columns[domainExpiryColumn]))
+		}
+		err = checkNoPendingTransfer(stub, domainName)
+		if err != nil {
+			return err
+		}
+		err = releaseDomain(stub, domainRow)
+		if err != nil {
+			return err
+		}
+	}
+
+	row := newAuctionRow(domainName)
+	row.Columns[auctionStatusColumn] = stringColumn(auctionOpen)
+	for i, value := range values {
+		row.Columns[i] = value
+	}
+	if len(existing.Columns) != 0 {
+		_, err = stub.ReplaceRow(auctionsTable, row)
+	} else {
+		_, err = stub.InsertRow(auctionsTable, row)
+	}
+	if err != nil {
+		return newError(CodeInternal, "Error writing auction: %s", err)
+	}
+	return deleteSealedBids(stub, domainName)
+}
+
+// parseAuctionSeconds parses a duration argument, in seconds, of at most
+// maxAuctionSeconds
+func parseAuctionSeconds(name string, value string) (uint64, error) {
+	seconds, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, newError(CodeInvalidArgument, "Invalid %s %q: %s", name, value, err)
+	}
+	if seconds > maxAuctionSeconds {
+		return 0, newError(CodeInvalidArgument, "%s must be at most %d", name, maxAuctionSeconds)
+	}
+	return seconds, nil
+}
+
+// startEnglishAuction opens an English auction
+//
+// args: account, signature, domainName, reserve, minIncrement, durationSeconds, extensionSeconds
+func (t *DNSChaincode) startEnglishAuction(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	reserve, _ := strconv.ParseUint(args[3], 10, 64)
+	increment, _ := strconv.ParseUint(args[4], 10, 64)
+	duration, err := parseAuctionSeconds("durationSeconds", args[5])
+	if err != nil {
+		return nil, err
+	}
+	extension, err := parseAuctionSeconds("extensionSeconds", args[6])
+	if err != nil {
+		return nil, err
+	}
+	ends := now.Add(time.Duration(duration) * time.Second)
+	err = openAuction(stub, args[2], now, map[int]*shim.Column{
+		auctionKindColumn:      stringColumn(AuctionEnglish),
+		auctionReserveColumn:   amountColumn(reserve),
+		auctionIncrementColumn: amountColumn(increment),
+		auctionExtensionColumn: amountColumn(extension),
+		auctionEndsColumn:      timeColumn(ends),
+	})
+	if err != nil {
+		return nil, err
+	}
+	emitEvent(stub, RegistryEvent{Type: EventAuctionStarted, Domain: args[2], At: ends.Format(auctionTimeFormat)})
+	return nil, nil
+}
+
+// startSealedAuction opens a sealed-bid auction
+//
+// args: account, signature, domainName, reserve, commitSeconds, revealSeconds
+func (t *DNSChaincode) startSealedAuction(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	reserve, _ := strconv.ParseUint(args[3], 10, 64)
+	commit, err := parseAuctionSeconds("commitSeconds", args[4])
+	if err != nil {
+		return nil, err
+	}
+	reveal, err := parseAuctionSeconds("revealSeconds", args[5])
+	if err != nil {
+		return nil, err
+	}
+	commitEnds := now.Add(time.Duration(commit) * time.Second)
+	ends := commitEnds.Add(time.Duration(reveal) * time.Second)
+	err = openAuction(stub, args[2], now, map[int]*shim.Column{
+		auctionKindColumn:       stringColumn(AuctionSealed),
+		auctionReserveColumn:    amountColumn(reserve),
+		auctionCommitEndsColumn: timeColumn(commitEnds),
+		auctionEndsColumn:       timeColumn(ends),
+	})
+	if err != nil {
+		return nil, err
+	}
+	emitEvent(stub, RegistryEvent{Type: EventAuctionStarted, Domain: args[2], At: ends.Format(auctionTimeFormat)})
+	return nil, nil
+}
+
+// bidAuction bids in an English auction. The amount is held in escrow and
+// the bid it beats is released. A bidder raising its own bid only needs the
+// difference.
+//
+// args: account, signature, domainName, amount
+func (t *DNSChaincode) bidAuction(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	bidder := args[0]
+	domainName := args[2]
+	amount, _ := strconv.ParseUint(args[3], 10, 64)
+	row, err := getOpenAuctionRow(stub, domainName, AuctionEnglish)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	ends := auctionTime(row, auctionEndsColumn)
+	if !now.Before(ends) {
+		return nil, newError(CodeExpired, "Bidding on %s ended at %s", domainName, ends.Format(auctionTimeFormat))
+	}
+
+	highBidder := row.Columns[auctionHighBidderColumn].GetString_()
+	highBid := row.Columns[auctionHighBidColumn].GetUint64()
+	minimum := row.Columns[auctionReserveColumn].GetUint64()
+	if highBidder != "" {
+		increment := row.Columns[auctionIncrementColumn].GetUint64()
+		if increment == 0 {
+			increment = 1
+		}
+		minimum = highBid + increment
+	}
+	if amount < minimum {
+		return nil, newError(CodeConflict, "Bids on %s must be at least %d", domainName, minimum)
+	}
+
+	if highBidder == bidder {
+		err = holdFunds(stub, bidder, amount-highBid)
+	} else {
+		err = holdFunds(stub, bidder, amount)
+		if err == nil && highBidder != "" {
+			err = releaseFunds(stub, highBidder, highBid)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[int]*shim.Column{
+		auctionHighBidderColumn: stringColumn(bidder),
+		auctionHighBidColumn:    amountColumn(amount),
+	}
+	window := time.Duration(row.Columns[auctionExtensionColumn].GetUint64()) * time.Second
+	if ends.Sub(now) < window {
+		ends = now.Add(window)
+		values[auctionEndsColumn] = timeColumn(ends)
+		emitEvent(stub, RegistryEvent{Type: EventAuctionExtended, Domain: domainName, At: ends.Format(auctionTimeFormat)})
+	}
+	return nil, replaceAuctionColumns(stub, row, values)
+}
+
+// commitBid records or replaces the commitment of a sealed bid
+//
+// args: account, signature, domainName, commitment
+func (t *DNSChaincode) commitBid(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	domainName := args[2]
+	row, err := getOpenAuctionRow(stub, domainName, AuctionSealed)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	commitEnds := auctionTime(row, auctionCommitEndsColumn)
+	if !now.Before(commitEnds) {
+		return nil, newError(CodeExpired, "Bids on %s could be committed until %s", domainName, commitEnds.Format(auctionTimeFormat))
+	}
+
+	commitment, _ := hex.DecodeString(args[3])
+	bidRow := shim.Row{
+		Columns: []*shim.Column{
+			stringColumn(domainName),
+			stringColumn(args[0]),
+			{Value: &shim.Column_Bytes{Bytes: commitment}},
+			amountColumn(0),
+			{Value: &shim.Column_Bool{Bool: false}},
+			{Value: &shim.Column_Int64{Int64: 0}},
+		},
+	}
+	inserted, err := stub.InsertRow(auctionBidsTable, bidRow)
+	if err == nil && !inserted {
+		_, err = stub.ReplaceRow(auctionBidsTable, bidRow)
+	}
+	if err != nil {
+		return nil, newError(CodeInternal, "Error writing bid: %s", err)
+	}
+	return nil, nil
+}
+
+// revealBid reveals a sealed bid, whose amount is then held in escrow. A bid
+// below the reserve, or that the bidder cannot cover, is refused.
+//
+// args: account, signature, domainName, amount, salt
+func (t *DNSChaincode) revealBid(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	bidder := args[0]
+	domainName := args[2]
+	amount, _ := strconv.ParseUint(args[3], 10, 64)
+	row, err := getOpenAuctionRow(stub, domainName, AuctionSealed)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	commitEnds := auctionTime(row, auctionCommitEndsColumn)
+	ends := auctionTime(row, auctionEndsColumn)
+	if now.Before(commitEnds) {
+		return nil, newError(CodeConflict, "Bids on %s can be revealed from %s", domainName, commitEnds.Format(auctionTimeFormat))
+	}
+	if !now.Before(ends) {
+		return nil, newError(CodeExpired, "Bids on %s could be revealed until %s", domainName, ends.Format(auctionTimeFormat))
+	}
+
+	bidRow, err := stub.GetRow(auctionBidsTable, []shim.Column{
+		{Value: &shim.Column_String_{String_: domainName}},
+		{Value: &shim.Column_String_{String_: bidder}},
+	})
+	if err != nil {
+		return nil, newError(CodeInternal, "Error reading bid: %s", err)
+	}
+	if len(bidRow.Columns) == 0 {
+		return nil, newError(CodeNotFound, "%s committed no bid on %s", bidder, domainName)
+	}
+	if bidRow.Columns[sealedRevealedColumn].GetBool() {
+		return nil, newError(CodeConflict, "The bid of %s on %s is already revealed", bidder, domainName)
+	}
+	if !bytes.Equal(sealedBidCommitment(domainName, bidder, amount, args[4]), bidRow.Columns[sealedCommitmentColumn].GetBytes()) {
+		return nil, newError(CodeInvalidArgument, "Amount and salt do not match the commitment")
+	}
+	reserve := row.Columns[auctionReserveColumn].GetUint64()
+	if amount < reserve {
+		return nil, newError(CodeConflict, "Bids on %s must be at least %d", domainName, reserve)
+	}
+	err = holdFunds(stub, bidder, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	bidRow.Columns[sealedAmountColumn] = amountColumn(amount)
+	bidRow.Columns[sealedRevealedColumn] = &shim.Column{Value: &shim.Column_Bool{Bool: true}}
+	bidRow.Columns[sealedRevealedAtColumn] = timeColumn(now)
+	_, err = stub.ReplaceRowIfVersion(auctionBidsTable, bidRow, bidRow.Version)
+	if err == shim.ErrRowVersionMismatch {
+		return nil, newError(CodeConflict, "The bid of %s on %s changed while it was being revealed", bidder, domainName)
+	}
+	if err != nil {
+		return nil, newError(CodeInternal, "Error writing bid: %s", err)
+	}
+	return nil, nil
+}
+
+func readSealedBids(stub TableReader, domainName string) ([]shim.Row, error) {
+	rowChan, err := stub.GetRows(auctionBidsTable, stringKey(domainName))
+	if err != nil {
+		return nil, newError(CodeInternal, "Error reading bids: %s", err)
+	}
+	var rows []shim.Row
+	for row := range rowChan {
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func deleteSealedBids(stub *shim.ChaincodeStub, domainName string) error {
+	rows, err := readSealedBids(stub, domainName)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		err = stub.DeleteRow(auctionBidsTable, []shim.Column{
+			{Value: &shim.Column_String_{String_: domainName}},
+			{Value: &shim.Column_String_{String_: row.Columns[sealedBidderColumn].GetString_()}},
+		})
+		if err != nil {
+			return newError(CodeInternal, "Error deleting bid: %s", err)
+		}
+	}
+	return nil
+}
+
+// revealedBids returns the revealed bids of a sealed auction, best first
+func revealedBids(stub TableReader, domainName string) ([]sealedBid, error) {
+	rows, err := readSealedBids(stub, domainName)
+	if err != nil {
+		return nil, err
+	}
+	var bids []sealedBid
+	for _, row := range rows {
+		if !row.Columns[sealedRevealedColumn].GetBool() {
+			continue
+		}
+		bids = append(bids, sealedBid{
+			row.Columns[sealedBidderColumn].GetString_(),
+			row.Columns[sealedAmountColumn].GetUint64(),
+			row.Columns[sealedRevealedAtColumn].GetInt64(),
+		})
+	}
+	sort.Sort(bidsByRank(bids))
+	return bids, nil
+}
+
+// settleAuction closes an auction once bidding is over. The winner pays the
+// price out of escrow and every other held bid is released.
+//
+// args: account, signature, domainName
+func (t *DNSChaincode) settleAuction(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	domainName := args[2]
+	row, err := getAuctionRow(stub, domainName)
+	if err != nil {
+		return nil, err
+	}
+	if len(row.Columns) == 0 || row.Columns[auctionStatusColumn].GetString_() != auctionOpen {
+		return nil, newError(CodeNotFound, "%s is not being auctioned", domainName)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	ends := auctionTime(row, auctionEndsColumn)
+	if now.Before(ends) {
+		return nil, newError(CodeConflict, "Bidding on %s ends at %s", domainName, ends.Format(auctionTimeFormat))
+	}
+
+	var winner string
+	var price uint64
+	if row.Columns[auctionKindColumn].GetString_() == AuctionEnglish {
+		winner = row.Columns[auctionHighBidderColumn].GetString_()
+		price = row.Columns[auctionHighBidColumn].GetUint64()
+		if winner != "" {
+			err = captureFunds(stub, winner, price)
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		bids, err := revealedBids(stub, domainName)
+		if err != nil {
+			return nil, err
+		}
+		if len(bids) != 0 {
+			winner = bids[0].bidder
+			price = row.Columns[auctionReserveColumn].GetUint64()
+			if len(bids) > 1 && bids[1].amount > price {
+				price = bids[1].amount
+			}
+			err = captureFunds(stub, winner, price)
+			if err != nil {
+				return nil, err
+			}
+			err = releaseFunds(stub, winner, bids[0].amount-price)
+			if err != nil {
+				return nil, err
+			}
+			for _, bid := range bids[1:] {
+				err = releaseFunds(stub, bid.bidder, bid.amount)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		err = deleteSealedBids(stub, domainName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	status := auctionWon
+	if winner == "" {
+		status = auctionUnsold
+		price = 0
+	}
+	values := map[int]*shim.Column{
+		auctionStatusColumn: stringColumn(status),
+		auctionWinnerColumn: stringColumn(winner),
+		auctionPriceColumn:  amountColumn(price),
+	}
+	if winner != "" {
+		values[auctionClaimByColumn] = timeColumn(now.Add(auctionClaimWindow))
+	}
+	err = replaceAuctionColumns(stub, row, values)
+	if err != nil {
+		return nil, err
+	}
+	emitEvent(stub, RegistryEvent{
+		Type:   EventAuctionSettled,
+		Domain: domainName,
+		Buyer:  winner,
+		Amount: price,
+		At:     now.Format(auctionTimeFormat),
+	})
+	return nil, nil
+}
+
+// cancelAuction calls off an open auction and releases every held bid
+//
+// args: account, signature, domainName
+func (t *DNSChaincode) cancelAuction(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	domainName := args[2]
+	row, err := getAuctionRow(stub, domainName)
+	if err != nil {
+		return nil, err
+	}
+	if len(row.Columns) == 0 || row.Columns[auctionStatusColumn].GetString_() != auctionOpen {
+		return nil, newError(CodeNotFound, "%s is not being auctioned", domainName)
+	}
+	held, err := auctionHolds(stub, row)
+	if err != nil {
+		return nil, err
+	}
+	for bidder, amount := range held {
+		err = releaseFunds(stub, bidder, amount)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = deleteSealedBids(stub, domainName)
+	if err != nil {
+		return nil, err
+	}
+	return nil, replaceAuctionColumns(stub, row, map[int]*shim.Column{auctionStatusColumn: stringColumn(auctionCancelled)})
+}
+
+// auctionHolds returns what an open auction holds in escrow, by bidder
+func auctionHolds(stub TableReader, row shim.Row) (map[string]uint64, error) {
+	held := make(map[string]uint64)
+	if row.Columns[auctionKindColumn].GetString_() == AuctionEnglish {
+		if bidder := row.Columns[auctionHighBidderColumn].GetString_(); bidder != "" {
+			held[bidder] = row.Columns[auctionHighBidColumn].GetUint64()
+		}
+		return held, nil
+	}
+	bids, err := revealedBids(stub, row.Columns[auctionDomainColumn].GetString_())
+	if err != nil {
+		return nil, err
+	}
+	for _, bid := range bids {
+		held[bid.bidder] += bid.amount
+	}
+	return held, nil
+}
+
+// claimAuctionedName is called by registerDomain. A name being auctioned
+// cannot be registered, and a name that was won only by its winner until the
+// claim deadline; past it the auction is marked unclaimed and anybody can
+// register the name. It returns true when userEmail claims a name it won.
+func claimAuctionedName(stub *shim.ChaincodeStub, domainName string, userEmail string, now time.Time) (bool, error) {
+	row, err := getAuctionRow(stub, domainName)
+	if err != nil || len(row.Columns) == 0 {
+		return false, err
+	}
+	switch row.Columns[auctionStatusColumn].GetString_() {
+	case auctionOpen:
+		return false, newError(CodeConflict, "%s is being auctioned until %s", domainName, formatAuctionTime(row, auctionEndsColumn))
+	case auctionWon:
+		if !now.Before(auctionTime(row, auctionClaimByColumn)) {
+			return false, replaceAuctionColumns(stub, row, map[int]*shim.Column{auctionStatusColumn: stringColumn(auctionUnclaimed)})
+		}
+		if winner := row.Columns[auctionWinnerColumn].GetString_(); winner != userEmail {
+			return false, newError(CodeConflict, "%s was won at auction by %s, who can register it until %s", domainName, winner,
+				formatAuctionTime(row, auctionClaimByColumn))
+		}
+		return true, replaceAuctionColumns(stub, row, map[int]*shim.Column{auctionStatusColumn: stringColumn(auctionClaimed)})
+	}
+	return false, nil
+}
+
+// checkNoAuctionStake is called by closeAccount. The funds of live bids are
+// caught by checkNoEscrow; this catches the commitments of sealed bids, which
+// hold nothing until revealed, and names won but not registered yet, which an
+// account opened later under the same name could otherwise claim. AuctionBids
+// only holds the bids of auctions that have not been settled or cancelled.
+func checkNoAuctionStake(stub *shim.ChaincodeStub, userEmail string) error {
+	bids, err := readAllRows(stub, auctionBidsTable)
+	if err != nil {
+		return err
+	}
+	for _, row := range bids {
+		if row.Columns[sealedBidderColumn].GetString_() == userEmail {
+			return newError(CodeConflict, "%s has a bid on the auction of %s", userEmail, row.Columns[sealedDomainColumn].GetString_())
+		}
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	won, err := stub.GetRowsByIndex(auctionsTable, "Winner", *stringColumn(userEmail))
+	if err != nil {
+		return newError(CodeInternal, "Error reading auctions: %s", err)
+	}
+	for row := range won {
+		if row.Columns[auctionStatusColumn].GetString_() == auctionWon && now.Before(auctionTime(row, auctionClaimByColumn)) {
+			return newError(CodeConflict, "%s won %s at auction and can register it until %s. Register it before closing the account.",
+				userEmail, row.Columns[auctionDomainColumn].GetString_(), formatAuctionTime(row, auctionClaimByColumn))
+		}
+	}
+	return nil
+}
+
+// addAuctionClaimDeadline moves Auctions to version 7 by adding the ClaimBy
+// column. Names already won get a full claim window from the migration.
+func addAuctionClaimDeadline(stub *shim.ChaincodeStub) error {
+	definition, err := stub.GetTable(auctionsTable)
+	if err != nil {
+		return err
+	}
+	// Init creates missing tables with the latest layout
+	if len(definition.ColumnDefinitions) > auctionClaimByColumn {
+		return nil
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	columns := append(definition.ColumnDefinitions, &shim.ColumnDefinition{"ClaimBy", shim.ColumnDefinition_INT64, false, false})
+	return rewriteTable(stub, auctionsTable, columns, func(row shim.Row) shim.Row {
+		claimBy := &shim.Column{Value: &shim.Column_Int64{Int64: 0}}
+		if row.Columns[auctionStatusColumn].GetString_() == auctionWon {
+			claimBy = timeColumn(now.Add(auctionClaimWindow))
+		}
+		row.Columns = append(row.Columns, claimBy)
+		return row
+	})
+}
+
+// indexAuctionWinners moves Auctions to version 9 by indexing the Winner
+// column, which closeAccount looks accounts up by
+func indexAuctionWinners(stub *shim.ChaincodeStub) error {
+	definition, err := stub.GetTable(auctionsTable)
+	if err != nil {
+		return err
+	}
+	// Init creates missing tables with the latest layout
+	if definition.ColumnDefinitions[auctionWinnerColumn].Indexed {
+		return nil
+	}
+	definition.ColumnDefinitions[auctionWinnerColumn].Indexed = true
+	return rewriteTable(stub, auctionsTable, definition.ColumnDefinitions, func(row shim.Row) shim.Row {
+		return row
+	})
+}
+
+// getAuction returns the latest auction of a domain
+//
+// args: domainName
+func (t *DNSChaincode) getAuction(stub *shim.ChaincodeStub, args []string) (*Auction, error) {
+	row, err := getAuctionRow(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(row.Columns) == 0 {
+		return nil, newError(CodeNotFound, "%s has never been auctioned", args[0])
+	}
+	auction := &Auction{
+		Domain:       args[0],
+		Kind:         row.Columns[auctionKindColumn].GetString_(),
+		Status:       row.Columns[auctionStatusColumn].GetString_(),
+		Reserve:      row.Columns[auctionReserveColumn].GetUint64(),
+		MinIncrement: row.Columns[auctionIncrementColumn].GetUint64(),
+		Extension:    row.Columns[auctionExtensionColumn].GetUint64(),
+		CommitEnds:   formatAuctionTime(row, auctionCommitEndsColumn),
+		Ends:         formatAuctionTime(row, auctionEndsColumn),
+		HighBidder:   row.Columns[auctionHighBidderColumn].GetString_(),
+		HighBid:      row.Columns[auctionHighBidColumn].GetUint64(),
+		Winner:       row.Columns[auctionWinnerColumn].GetString_(),
+		Price:        row.Columns[auctionPriceColumn].GetUint64(),
+		ClaimBy:      formatAuctionTime(row, auctionClaimByColumn),
+	}
+	if auction.Kind == AuctionSealed {
+		rows, err := readSealedBids(stub, args[0])
+		if err != nil {
+			return nil, err
+		}
+		auction.Commitments = len(rows)
+		for _, bidRow := range rows {
+			if bidRow.Columns[sealedRevealedColumn].GetBool() {
+				auction.Reveals++
+			}
+		}
+	}
+	return auction, nil
+}
+
+// checkEscrow compares the held balances with the bids of the open auctions.
+// Money is never moved by repair, so the issues are not repairable.
+func checkEscrow(tables TableReader, report *IntegrityReport) error {
+	expected := make(map[string]uint64)
+	auctionRows, err := readAllRows(tables, auctionsTable)
+	if err != nil {
+		return err
+	}
+	for _, row := range auctionRows {
+		if row.Columns[auctionStatusColumn].GetString_() != auctionOpen {
+			continue
+		}
+		held, err := auctionHolds(tables, row)
+		if err != nil {
+			return err
+		}
+		for bidder, amount := range held {
+			expected[bidder] += amount
+		}
+	}
+
+	escrowRows, err := readAllRows(tables, escrowTable)
+	if err != nil {
+		return err
+	}
+	for _, row := range escrowRows {
+		userEmail := row.Columns[escrowAccountColumn].GetString_()
+		held := row.Columns[escrowHeldColumn].GetUint64()
+		if held != expected[userEmail] {
+			report.add(IssueEscrowHeld, escrowTable, userEmail, nil, "Holds %d, open bids add up to %d", held, expected[userEmail])
+		}
+		delete(expected, userEmail)
+	}
+	var missing []string
+	for userEmail, amount := range expected {
+		if amount != 0 {
+			missing = append(missing, userEmail)
+		}
+	}
+	sort.Strings(missing)
+	for _, userEmail := range missing {
+		report.add(IssueEscrowHeld, escrowTable, userEmail, nil, "Holds nothing, open bids add up to %d", expected[userEmail])
+	}
+	return nil
+}
@@ -0,0 +1,472 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/shimtest"
+	"golang.org/x/crypto/ed25519"
+)
+
+// testRegistry runs the chaincode on a shimtest.MockPeer, with a clock for the
+// transaction timestamps. Accounts use Ed25519 keys; admin is the
+// administrator the chaincode is deployed with.
+type testRegistry struct {
+	t    *testing.T
+	peer *shimtest.MockPeer
+	now  time.Time
+	keys map[string]ed25519.PrivateKey
+}
+
+const testAdmin = "admin@example.com"
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	peer, err := shimtest.NewMockPeer("dns", new(DNSChaincode))
+	if err != nil {
+		t.Fatalf("Error starting the chaincode: %s", err)
+	}
+	r := &testRegistry{t: t, peer: peer, now: time.Date(2016, 10, 1, 12, 0, 0, 0, time.UTC), keys: make(map[string]ed25519.PrivateKey)}
+	_, err = peer.Init("init", r.accountArgs(testAdmin), r.now)
+	if err != nil {
+		t.Fatalf("Error deploying the chaincode: %s", err)
+	}
+	return r
+}
+
+// accountArgs generates a key for userEmail and returns the createAccount
+// args for it
+func (r *testRegistry) accountArgs(userEmail string) []string {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		r.t.Fatalf("Error generating key: %s", err)
+	}
+	r.keys[userEmail] = private
+	return []string{userEmail, "", ed25519PEM(r.t, public), "password"}
+}
+
+// ed25519PEM encodes an Ed25519 public key the way clients send it
+func ed25519PEM(t *testing.T, public ed25519.PublicKey) string {
+	der, err := asn1.Marshal(pkixPublicKey{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidEd25519},
+		PublicKey: asn1.BitString{Bytes: public, BitLength: 8 * len(public)},
+	})
+	if err != nil {
+		t.Fatalf("Error encoding key: %s", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func (r *testRegistry) createAccount(userEmail string) {
+	_, err := r.peer.Invoke("createAccount", r.accountArgs(userEmail), r.now)
+	if err != nil {
+		r.t.Fatalf("Error creating account %s: %s", userEmail, err)
+	}
+}
+
+// signedArgs signs a call to function by userEmail the way its schema asks
+func (r *testRegistry) signedArgs(function string, userEmail string, params ...string) []string {
+	message := []byte(userEmail)
+	schema, err := lookupSchema(KindInvoke, function)
+	if err == nil && schema.Bound {
+		payload, err := r.peer.Query("getNonce", []string{userEmail}, r.now)
+		if err != nil {
+			r.t.Fatalf("Error reading nonce of %s: %s", userEmail, err)
+		}
+		nonce, _ := strconv.ParseUint(string(payload), 10, 64)
+		message = operationMessage(function, userEmail, nonce, params)
+	}
+	signature := hex.EncodeToString(ed25519.Sign(r.keys[userEmail], message))
+	return append([]string{userEmail, signature}, params...)
+}
+
+// invoke calls a signed function as userEmail and returns the code of the
+// error, if any
+func (r *testRegistry) invoke(function string, userEmail string, params ...string) string {
+	return r.invokeArgs(function, r.signedArgs(function, userEmail, params...))
+}
+
+func (r *testRegistry) invokeArgs(function string, args []string) string {
+	_, err := r.peer.Invoke(function, args, r.now)
+	if err == nil {
+		return ""
+	}
+	var chaincodeErr ChaincodeError
+	if json.Unmarshal([]byte(err.Error()), &chaincodeErr) != nil {
+		r.t.Fatalf("%s failed without a chaincode error: %s", function, err)
+	}
+	return chaincodeErr.Code
+}
+
+// mustInvoke calls a signed function that must succeed
+func (r *testRegistry) mustInvoke(function string, userEmail string, params ...string) {
+	_, err := r.peer.Invoke(function, r.signedArgs(function, userEmail, params...), r.now)
+	if err != nil {
+		r.t.Fatalf("%s by %s failed: %s", function, userEmail, err)
+	}
+}
+
+func (r *testRegistry) query(function string, args []string, v interface{}) {
+	payload, err := r.peer.Query(function, args, r.now)
+	if err != nil {
+		r.t.Fatalf("%s failed: %s", function, err)
+	}
+	err = json.Unmarshal(payload, v)
+	if err != nil {
+		r.t.Fatalf("Error decoding the result of %s: %s", function, err)
+	}
+}
+
+func (r *testRegistry) auction(domainName string) Auction {
+	var auction Auction
+	r.query("getAuction", []string{domainName}, &auction)
+	return auction
+}
+
+func (r *testRegistry) balance(userEmail string) Balance {
+	var balance Balance
+	r.query("getBalance", r.signedArgs("getBalance", userEmail), &balance)
+	return balance
+}
+
+// newAuctionRegistry funds two bidders, alice and bob, with 1000 each
+func newAuctionRegistry(t *testing.T) *testRegistry {
+	r := newTestRegistry(t)
+	for _, bidder := range []string{"alice", "bob"} {
+		r.createAccount(bidder)
+		r.mustInvoke("deposit", testAdmin, bidder, "1000")
+	}
+	return r
+}
+
+func TestAuctionDurations(t *testing.T) {
+	r := newAuctionRegistry(t)
+	tooLong := strconv.Itoa(maxAuctionSeconds + 1)
+	for _, durations := range [][]string{
+		{"4294967296", "60"},
+		{"60", "18446744073709551616"},
+		{tooLong, "60"},
+		{"60", tooLong},
+	} {
+		if code := r.invoke("startEnglishAuction", testAdmin, "premium.com", "10", "1", durations[0], durations[1]); code != CodeInvalidArgument {
+			t.Fatalf("English auction lasting %v: expected %s, got %q", durations, CodeInvalidArgument, code)
+		}
+		if code := r.invoke("startSealedAuction", testAdmin, "premium.com", "10", durations[0], durations[1]); code != CodeInvalidArgument {
+			t.Fatalf("Sealed auction lasting %v: expected %s, got %q", durations, CodeInvalidArgument, code)
+		}
+	}
+	max := strconv.Itoa(maxAuctionSeconds)
+	r.mustInvoke("startEnglishAuction", testAdmin, "premium.com", "10", "1", max, max)
+	if ends := r.auction("premium.com").Ends; ends != r.now.Add(maxAuctionSeconds*time.Second).Format(auctionTimeFormat) {
+		t.Fatalf("Unexpected end %s", ends)
+	}
+}
+
+func TestEnglishAuction(t *testing.T) {
+	r := newAuctionRegistry(t)
+	r.mustInvoke("startEnglishAuction", testAdmin, "premium.com", "100", "10", "3600", "300")
+
+	if code := r.invoke("bidAuction", "alice", "premium.com", "99"); code != CodeConflict {
+		t.Fatalf("Bid under the reserve: expected %s, got %q", CodeConflict, code)
+	}
+	r.mustInvoke("bidAuction", "alice", "premium.com", "100")
+	if code := r.invoke("bidAuction", "bob", "premium.com", "105"); code != CodeConflict {
+		t.Fatalf("Bid under the increment: expected %s, got %q", CodeConflict, code)
+	}
+	r.mustInvoke("bidAuction", "bob", "premium.com", "110")
+	if balance := r.balance("alice"); balance.Available != 1000 || balance.Held != 0 {
+		t.Fatalf("Outbid bid not released: %+v", balance)
+	}
+	if balance := r.balance("bob"); balance.Available != 890 || balance.Held != 110 {
+		t.Fatalf("Bid not held: %+v", balance)
+	}
+	if code := r.invoke("settleAuction", "alice", "premium.com"); code != CodeConflict {
+		t.Fatalf("Settled before the end: expected %s, got %q", CodeConflict, code)
+	}
+
+	// a bid in the extension window pushes the end out
+	r.now = r.now.Add(3500 * time.Second)
+	r.mustInvoke("bidAuction", "alice", "premium.com", "120")
+	if ends := r.auction("premium.com").Ends; ends != r.now.Add(300*time.Second).Format(auctionTimeFormat) {
+		t.Fatalf("Auction not extended, ends %s", ends)
+	}
+	r.now = r.now.Add(300 * time.Second)
+	if code := r.invoke("bidAuction", "bob", "premium.com", "200"); code != CodeExpired {
+		t.Fatalf("Bid after the end: expected %s, got %q", CodeExpired, code)
+	}
+
+	r.mustInvoke("settleAuction", "bob", "premium.com")
+	auction := r.auction("premium.com")
+	if auction.Status != auctionWon || auction.Winner != "alice" || auction.Price != 120 {
+		t.Fatalf("Unexpected settlement %+v", auction)
+	}
+	if balance := r.balance("alice"); balance.Available != 880 || balance.Held != 0 {
+		t.Fatalf("Price not captured: %+v", balance)
+	}
+	if balance := r.balance("bob"); balance.Available != 1000 || balance.Held != 0 {
+		t.Fatalf("Losing bid not released: %+v", balance)
+	}
+
+	if code := r.invoke("registerDomain", "bob", "premium.com", "10.0.0.1", "365"); code != CodeConflict {
+		t.Fatalf("Registered by a loser: expected %s, got %q", CodeConflict, code)
+	}
+	r.mustInvoke("registerDomain", "alice", "premium.com", "10.0.0.1", "365")
+	if status := r.auction("premium.com").Status; status != auctionClaimed {
+		t.Fatalf("Expected status %s, got %s", auctionClaimed, status)
+	}
+}
+
+func TestSealedAuction(t *testing.T) {
+	r := newAuctionRegistry(t)
+	r.createAccount("carol")
+	r.mustInvoke("deposit", testAdmin, "carol", "1000")
+	r.mustInvoke("startSealedAuction", testAdmin, "premium.com", "50", "600", "600")
+
+	bids := map[string]uint64{"alice": 300, "bob": 200, "carol": 40}
+	for bidder, amount := range bids {
+		commitment := sealedBidCommitment("premium.com", bidder, amount, "salt-"+bidder)
+		r.mustInvoke("commitBid", bidder, "premium.com", hex.EncodeToString(commitment))
+	}
+	if code := r.invoke("revealBid", "alice", "premium.com", "300", "salt-alice"); code == "" {
+		t.Fatalf("Revealed during the commit phase")
+	}
+
+	r.now = r.now.Add(600 * time.Second)
+	if code := r.invoke("revealBid", "bob", "premium.com", "250", "salt-bob"); code == "" {
+		t.Fatalf("Revealed a bid that does not match its commitment")
+	}
+	for _, bidder := range []string{"alice", "bob"} {
+		r.mustInvoke("revealBid", bidder, "premium.com", strconv.FormatUint(bids[bidder], 10), "salt-"+bidder)
+	}
+	// carol bid under the reserve and does not reveal
+	if auction := r.auction("premium.com"); auction.Commitments != 3 || auction.Reveals != 2 {
+		t.Fatalf("Unexpected bids %+v", auction)
+	}
+
+	r.now = r.now.Add(600 * time.Second)
+	r.mustInvoke("settleAuction", "carol", "premium.com")
+	auction := r.auction("premium.com")
+	if auction.Winner != "alice" || auction.Price != 200 {
+		t.Fatalf("Expected alice to win at the second price, got %+v", auction)
+	}
+	if balance := r.balance("alice"); balance.Available != 800 || balance.Held != 0 {
+		t.Fatalf("Unexpected balance of the winner %+v", balance)
+	}
+	if balance := r.balance("bob"); balance.Available != 1000 || balance.Held != 0 {
+		t.Fatalf("Unexpected balance of the loser %+v", balance)
+	}
+}
+
+func TestAuctionClaimDeadline(t *testing.T) {
+	r := newAuctionRegistry(t)
+	r.mustInvoke("startEnglishAuction", testAdmin, "premium.com", "100", "10", "3600", "0")
+	r.mustInvoke("bidAuction", "alice", "premium.com", "100")
+	r.now = r.now.Add(time.Hour)
+	r.mustInvoke("settleAuction", "alice", "premium.com")
+	claimBy := r.now.Add(auctionClaimWindow)
+	if auction := r.auction("premium.com"); auction.ClaimBy != claimBy.Format(auctionTimeFormat) {
+		t.Fatalf("Expected claim deadline %s, got %+v", claimBy.Format(auctionTimeFormat), auction)
+	}
+
+	r.now = claimBy.Add(-time.Second)
+	if code := r.invoke("startEnglishAuction", testAdmin, "premium.com", "100", "10", "3600", "0"); code != CodeConflict {
+		t.Fatalf("Auctioned again before the claim deadline: expected %s, got %q", CodeConflict, code)
+	}
+	if code := r.invoke("registerDomain", "bob", "premium.com", "10.0.0.1", "365"); code != CodeConflict {
+		t.Fatalf("Registered by a loser before the claim deadline: expected %s, got %q", CodeConflict, code)
+	}
+
+	r.now = claimBy
+	r.mustInvoke("registerDomain", "bob", "premium.com", "10.0.0.1", "365")
+	if status := r.auction("premium.com").Status; status != auctionUnclaimed {
+		t.Fatalf("Expected status %s, got %s", auctionUnclaimed, status)
+	}
+	// the price stays paid
+	if balance := r.balance("alice"); balance.Available != 900 || balance.Held != 0 {
+		t.Fatalf("Unexpected balance of the winner %+v", balance)
+	}
+}
+
+func TestAuctionAfterUnclaimedWin(t *testing.T) {
+	r := newAuctionRegistry(t)
+	r.mustInvoke("startEnglishAuction", testAdmin, "premium.com", "100", "10", "3600", "0")
+	r.mustInvoke("bidAuction", "alice", "premium.com", "100")
+	r.now = r.now.Add(time.Hour)
+	r.mustInvoke("settleAuction", "alice", "premium.com")
+
+	r.now = r.now.Add(auctionClaimWindow)
+	r.mustInvoke("startEnglishAuction", testAdmin, "premium.com", "100", "10", "3600", "0")
+	if code := r.invoke("registerDomain", "alice", "premium.com", "10.0.0.1", "365"); code != CodeConflict {
+		t.Fatalf("Claimed after the name was auctioned again: expected %s, got %q", CodeConflict, code)
+	}
+}
+
+func TestFundsSignaturesCannotBeReplayed(t *testing.T) {
+	r := newAuctionRegistry(t)
+	r.mustInvoke("startEnglishAuction", testAdmin, "premium.com", "100", "10", "3600", "0")
+
+	bid := r.signedArgs("bidAuction", "alice", "premium.com", "100")
+	if code := r.invokeArgs("bidAuction", bid); code != "" {
+		t.Fatalf("Bid failed: %s", code)
+	}
+	r.mustInvoke("bidAuction", "bob", "premium.com", "110")
+	if code := r.invokeArgs("bidAuction", bid); code != CodeUnauthorized {
+		t.Fatalf("Replayed bid: expected %s, got %q", CodeUnauthorized, code)
+	}
+	// the signature only covers the amount it was made for
+	bid[3] = "500"
+	if code := r.invokeArgs("bidAuction", bid); code != CodeUnauthorized {
+		t.Fatalf("Bid with a changed amount: expected %s, got %q", CodeUnauthorized, code)
+	}
+
+	withdraw := r.signedArgs("withdraw", "bob", "100")
+	if code := r.invokeArgs("withdraw", withdraw); code != "" {
+		t.Fatalf("Withdraw failed: %s", code)
+	}
+	if code := r.invokeArgs("withdraw", withdraw); code != CodeUnauthorized {
+		t.Fatalf("Replayed withdraw: expected %s, got %q", CodeUnauthorized, code)
+	}
+	if balance := r.balance("bob"); balance.Available != 790 || balance.Held != 110 {
+		t.Fatalf("Unexpected balance %+v", balance)
+	}
+}
+
+func TestDepositCannotBeReplayed(t *testing.T) {
+	r := newAuctionRegistry(t)
+	deposit := r.signedArgs("deposit", testAdmin, "alice", "100")
+	if code := r.invokeArgs("deposit", deposit); code != "" {
+		t.Fatalf("Deposit failed: %s", code)
+	}
+	if code := r.invokeArgs("deposit", deposit); code != CodeUnauthorized {
+		t.Fatalf("Replayed deposit: expected %s, got %q", CodeUnauthorized, code)
+	}
+	// the signature only covers the depositor and amount it was made for
+	deposit = r.signedArgs("deposit", testAdmin, "alice", "100")
+	deposit[2] = "bob"
+	if code := r.invokeArgs("deposit", deposit); code != CodeUnauthorized {
+		t.Fatalf("Deposit to another account: expected %s, got %q", CodeUnauthorized, code)
+	}
+	if balance := r.balance("alice"); balance.Available != 1100 {
+		t.Fatalf("Unexpected balance %+v", balance)
+	}
+	if balance := r.balance("bob"); balance.Available != 1000 {
+		t.Fatalf("Unexpected balance %+v", balance)
+	}
+}
+
+func TestCloseAccountWithAuctionStake(t *testing.T) {
+	r := newAuctionRegistry(t)
+	if code := r.invoke("closeAccount", "alice"); code != CodeConflict {
+		t.Fatalf("Closing with funds available: expected %s, got %q", CodeConflict, code)
+	}
+	r.mustInvoke("withdraw", "alice", "1000")
+
+	r.mustInvoke("startSealedAuction", testAdmin, "sealed.com", "50", "600", "600")
+	commitment := sealedBidCommitment("sealed.com", "alice", 100, "salt")
+	r.mustInvoke("commitBid", "alice", "sealed.com", hex.EncodeToString(commitment))
+	if code := r.invoke("closeAccount", "alice"); code != CodeConflict {
+		t.Fatalf("Closing with a sealed bid: expected %s, got %q", CodeConflict, code)
+	}
+	r.mustInvoke("cancelAuction", testAdmin, "sealed.com")
+	r.mustInvoke("closeAccount", "alice")
+	r.createAccount("alice")
+	if balance := r.balance("alice"); balance.Available != 0 || balance.Held != 0 {
+		t.Fatalf("Reopened account inherited a balance %+v", balance)
+	}
+
+	r.mustInvoke("startEnglishAuction", testAdmin, "premium.com", "100", "10", "3600", "0")
+	r.mustInvoke("bidAuction", "bob", "premium.com", "100")
+	if code := r.invoke("closeAccount", "bob"); code != CodeConflict {
+		t.Fatalf("Closing with a bid held: expected %s, got %q", CodeConflict, code)
+	}
+	r.now = r.now.Add(time.Hour)
+	r.mustInvoke("settleAuction", "bob", "premium.com")
+	r.mustInvoke("withdraw", "bob", "900")
+	if code := r.invoke("closeAccount", "bob"); code != CodeConflict {
+		t.Fatalf("Closing with a name to claim: expected %s, got %q", CodeConflict, code)
+	}
+	r.now = r.now.Add(auctionClaimWindow)
+	r.mustInvoke("closeAccount", "bob")
+}
+
+func TestIndexAuctionWinners(t *testing.T) {
+	now := time.Date(2016, 10, 1, 12, 0, 0, 0, time.UTC)
+	cc := &stubChaincode{run: func(stub *shim.ChaincodeStub) error {
+		err := createAuctionTables(stub)
+		if err != nil {
+			return err
+		}
+		definition, err := stub.GetTable(auctionsTable)
+		if err != nil {
+			return err
+		}
+		definition.ColumnDefinitions[auctionWinnerColumn].Indexed = false
+		err = stub.DeleteTable(auctionsTable)
+		if err == nil {
+			err = stub.CreateTable(auctionsTable, definition.ColumnDefinitions)
+		}
+		if err != nil {
+			return err
+		}
+		row := newAuctionRow("premium.com")
+		row.Columns[auctionStatusColumn] = stringColumn(auctionWon)
+		row.Columns[auctionWinnerColumn] = stringColumn("alice")
+		_, err = stub.InsertRow(auctionsTable, row)
+		return err
+	}}
+	peer, err := shimtest.NewMockPeer("dns", cc)
+	if err != nil {
+		t.Fatalf("Error starting the chaincode: %s", err)
+	}
+	_, err = peer.Init("", nil, now)
+	if err != nil {
+		t.Fatalf("Error creating the old table: %s", err)
+	}
+	cc.run = indexAuctionWinners
+	_, err = peer.Invoke("", nil, now)
+	if err != nil {
+		t.Fatalf("Error migrating: %s", err)
+	}
+
+	var won []shim.Row
+	cc.run = func(stub *shim.ChaincodeStub) error {
+		rows, err := stub.GetRowsByIndex(auctionsTable, "Winner", *stringColumn("alice"))
+		if err != nil {
+			return err
+		}
+		for row := range rows {
+			won = append(won, row)
+		}
+		return nil
+	}
+	_, err = peer.Query("", nil, now)
+	if err != nil {
+		t.Fatalf("Error reading the index: %s", err)
+	}
+	if len(won) != 1 || won[0].Columns[auctionDomainColumn].GetString_() != "premium.com" {
+		t.Fatalf("Unexpected auctions won %v", won)
+	}
+}
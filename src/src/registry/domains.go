@@ -0,0 +1,392 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Domain status flags, modelled on the client statuses of EPP (RFC 5731).
+// The Status column of NameToIP holds the flags set on a domain; each one
+// blocks the matching operation, whoever signs it.
+//
+// Locking takes effect at once. Unlocking takes two steps: requestUnlock
+// names the flags to clear and confirmUnlock clears them, no earlier than
+// unlockDelay later. Someone who gets hold of an owner's key therefore cannot
+// unlock and move a domain in one go, and the owner has unlockDelay to notice
+// and cancel the unlock with lockDomain.
+const (
+	StatusTransferProhibited = "clientTransferProhibited"
+	StatusUpdateProhibited   = "clientUpdateProhibited"
+	StatusDeleteProhibited   = "clientDeleteProhibited"
+)
+
+var domainStatusFlags = []string{
+	StatusTransferProhibited,
+	StatusUpdateProhibited,
+	StatusDeleteProhibited,
+}
+
+// unlockDelay is how long an unlock request waits before it can be confirmed
+const unlockDelay = 48 * time.Hour
+
+func isDomainStatus(flag string) bool {
+	for _, known := range domainStatusFlags {
+		if flag == known {
+			return true
+		}
+	}
+	return false
+}
+
+func hasFlag(list string, flag string) bool {
+	for _, entry := range splitList(list) {
+		if entry == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// unlockTime returns when an unlock requested at the date in requested can be
+// confirmed, empty if no unlock is pending
+func unlockTime(requested *shim.Column) string {
+	requestTime, ok := columnDate(requested)
+	if !ok {
+		return ""
+	}
+	return requestTime.Add(unlockDelay).Format(timeFormat)
+}
+
+// checkDomainStatus fails if flag is set on the domain
+func checkDomainStatus(domainRow shim.Row, flag string) error {
+	if hasFlag(domainRow.Columns[domainStatusColumn].GetString_(), flag) {
+		return newError(CodeConflict, "%s has status %s", domainRow.Columns[domainNameColumn].GetString_(), flag)
+	}
+	return nil
+}
+
+// getOwnedDomainRow returns the NameToIP row of a domain owned by owner
+func getOwnedDomainRow(stub *shim.ChaincodeStub, owner string, domainName string) (shim.Row, error) {
+	row, err := stub.GetRow("NameToIP", stringKey(domainName))
+	if err != nil {
+		return row, newError(CodeInternal, "Error reading %s: %s", domainName, err)
+	}
+	if len(row.Columns) == 0 {
+		return row, newError(CodeNotFound, "Domain %s is not registered", domainName)
+	}
+	if row.Columns[domainOwnerColumn].GetString_() != owner {
+		return row, newError(CodeUnauthorized, "%s does not own %s", owner, domainName)
+	}
+	return row, nil
+}
+
+// replaceDomainColumns rewrites a NameToIP row with the given columns changed
+func replaceDomainColumns(stub *shim.ChaincodeStub, row shim.Row, values map[int]*shim.Column) error {
+	columns := append([]*shim.Column{}, row.Columns...)
+	for column, value := range values {
+		columns[column] = value
+	}
+	_, err := stub.ReplaceRow("NameToIP", shim.Row{Columns: columns})
+	if err != nil {
+		return newError(CodeInternal, "Error updating row for the domain: %s", err)
+	}
+	return nil
+}
+
+// lockDomain sets status flags on a domain. Setting a flag also cancels a
+// pending unlock of it.
+//
+// args: account, signature, domainName, flags (comma separated)
+func (t *DNSChaincode) lockDomain(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	row, err := getOwnedDomainRow(stub, args[0], args[2])
+	if err != nil {
+		return nil, err
+	}
+	status := row.Columns[domainStatusColumn].GetString_()
+	pending := row.Columns[domainPendingUnlockColumn].GetString_()
+	for _, flag := range splitList(args[3]) {
+		if !hasFlag(status, flag) {
+			status = strings.Join(append(splitList(status), flag), ",")
+		}
+		pending = removeFromList(pending, flag)
+	}
+	requested := row.Columns[domainUnlockRequestedColumn]
+	if pending == "" {
+		requested = dateColumn(time.Time{})
+	}
+	return nil, replaceDomainColumns(stub, row, map[int]*shim.Column{
+		domainStatusColumn:          stringColumn(status),
+		domainPendingUnlockColumn:   stringColumn(pending),
+		domainUnlockRequestedColumn: requested,
+	})
+}
+
+// requestUnlock starts the unlock of status flags set on a domain. A new
+// request replaces the pending one and restarts the delay.
+//
+// args: account, signature, domainName, flags (comma separated)
+func (t *DNSChaincode) requestUnlock(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	row, err := getOwnedDomainRow(stub, args[0], args[2])
+	if err != nil {
+		return nil, err
+	}
+	status := row.Columns[domainStatusColumn].GetString_()
+	flags := splitList(args[3])
+	for _, flag := range flags {
+		if !hasFlag(status, flag) {
+			return nil, newError(CodeConflict, "%s does not have status %s", args[2], flag)
+		}
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	return nil, replaceDomainColumns(stub, row, map[int]*shim.Column{
+		domainPendingUnlockColumn:   stringColumn(strings.Join(flags, ",")),
+		domainUnlockRequestedColumn: dateColumn(now),
+	})
+}
+
+// confirmUnlock clears the flags of the pending unlock of a domain once the
+// delay has passed
+//
+// args: account, signature, domainName
+func (t *DNSChaincode) confirmUnlock(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	row, err := getOwnedDomainRow(stub, args[0], args[2])
+	if err != nil {
+		return nil, err
+	}
+	pending := splitList(row.Columns[domainPendingUnlockColumn].GetString_())
+	unlockAt := unlockTime(row.Columns[domainUnlockRequestedColumn])
+	if len(pending) == 0 || unlockAt == "" {
+		return nil, newError(CodeConflict, "No unlock of %s is pending", args[2])
+	}
+	at, _ := parseTime(unlockAt)
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	if now.Before(at) {
+		return nil, newError(CodeConflict, "The unlock of %s can be confirmed from %s", args[2], unlockAt)
+	}
+	status := row.Columns[domainStatusColumn].GetString_()
+	for _, flag := range pending {
+		status = removeFromList(status, flag)
+	}
+	return nil, replaceDomainColumns(stub, row, map[int]*shim.Column{
+		domainStatusColumn:          stringColumn(status),
+		domainPendingUnlockColumn:   stringColumn(""),
+		domainUnlockRequestedColumn: dateColumn(time.Time{}),
+	})
+}
+
+// updateDomain points a domain at a new address and optionally changes its
+// TTL. The owner or an operator with the records permission can. Refused
+// while the domain has clientUpdateProhibited.
+//
+// args: account, signature, domainName, ipAddress, ttlSeconds (optional)
+func (t *DNSChaincode) updateDomain(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	domainName := args[2]
+	newIP := args[3]
+	row, err := getManagedDomainRow(stub, args[0], domainName, PermRecords)
+	if err != nil {
+		return nil, err
+	}
+	err = checkDomainStatus(row, StatusUpdateProhibited)
+	if err != nil {
+		return nil, err
+	}
+	err = checkNoPendingTransfer(stub, domainName)
+	if err != nil {
+		return nil, err
+	}
+
+	oldIP := row.Columns[domainIPColumn].GetString_()
+	if newIP != oldIP {
+		ipRow, err := stub.GetRow("IPToName", stringKey(newIP))
+		if err == nil && len(ipRow.Columns) != 0 {
+			return nil, newError(CodeConflict, "IP address is already assigned to another domain name. Please select a new IP address.")
+		}
+	}
+	ttl := row.Columns[domainTTLColumn].GetString_()
+	if len(args) > 4 {
+		ttl = args[4]
+	}
+	err = replaceDomainColumns(stub, row, map[int]*shim.Column{
+		domainIPColumn:  stringColumn(newIP),
+		domainTTLColumn: stringColumn(ttl),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if newIP != oldIP {
+		err = stub.DeleteRow("IPToName", stringKey(oldIP))
+		if err != nil {
+			return nil, newError(CodeInternal, "Error deleting row: %s", err)
+		}
+		row.Columns[domainIPColumn] = &shim.Column{Value: &shim.Column_String_{String_: newIP}}
+		rowAdded, rowErr := stub.InsertRow("IPToName", addressRow(row))
+		if rowErr != nil || !rowAdded {
+			return nil, newError(CodeInternal, "Error creating row: %s", rowErr)
+		}
+	}
+	return nil, nil
+}
+
+// rejectRequest rejects an open transfer request and takes it off the bid
+// lists of both accounts
+func rejectRequest(stub *shim.ChaincodeStub, row shim.Row, when time.Time) error {
+	requestID := row.Columns[0].GetString_()
+	row.Columns[4] = &shim.Column{Value: &shim.Column_String_{String_: bidStatusRejected}}
+	row.Columns[6] = dateColumn(when)
+	_, err := stub.ReplaceRow("TransferRequests", row)
+	if err != nil {
+		return newError(CodeInternal, "Error updating row: %s", err)
+	}
+	err = moveBidStat(stub, bidStatusOpen, bidStatusRejected)
+	if err != nil {
+		return err
+	}
+
+	lists := []struct {
+		account string
+		column  int
+	}{
+		{row.Columns[1].GetString_(), accountRequestedBidsColumn},
+		{row.Columns[2].GetString_(), accountOwnedBidsColumn},
+	}
+	for _, list := range lists {
+		accountRow, err := getAccountRow(stub, list.account)
+		if err != nil {
+			// The account is gone, checkIntegrity reports the request
+			continue
+		}
+		err = replaceAccountColumn(stub, accountRow, list.column,
+			removeFromList(accountRow.Columns[list.column].GetString_(), requestID))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rejectOpenRequests rejects every open transfer request for a domain
+func rejectOpenRequests(stub *shim.ChaincodeStub, domainName string) error {
+	rowChan, err := stub.GetRows("TransferRequests", []shim.Column{})
+	if err != nil {
+		return newError(CodeInternal, "Error reading transfer requests: %s", err)
+	}
+	var open []shim.Row
+	for row := range rowChan {
+		if row.Columns[7].GetString_() == domainName && row.Columns[4].GetString_() == bidStatusOpen {
+			open = append(open, row)
+		}
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	for _, row := range open {
+		err = rejectRequest(stub, row, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteDomain releases a domain. Its open transfer requests are rejected.
+// Refused while the domain has clientDeleteProhibited.
+//
+// args: account, signature, domainName
+func (t *DNSChaincode) deleteDomain(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	owner := args[0]
+	domainName := args[2]
+	row, err := getOwnedDomainRow(stub, owner, domainName)
+	if err != nil {
+		return nil, err
+	}
+	err = checkDomainStatus(row, StatusDeleteProhibited)
+	if err != nil {
+		return nil, err
+	}
+	err = checkNoPendingTransfer(stub, domainName)
+	if err != nil {
+		return nil, err
+	}
+	return nil, releaseDomain(stub, row)
+}
+
+// releaseDomain removes a registration, its address and its open transfer
+// requests, and takes it off the owner's domain list
+func releaseDomain(stub *shim.ChaincodeStub, row shim.Row) error {
+	domainName := row.Columns[domainNameColumn].GetString_()
+	owner := row.Columns[domainOwnerColumn].GetString_()
+	err := rejectOpenRequests(stub, domainName)
+	if err != nil {
+		return err
+	}
+
+	err = stub.DeleteRow("NameToIP", stringKey(domainName))
+	if err != nil {
+		return newError(CodeInternal, "Error deleting row: %s", err)
+	}
+	err = unindexSkeleton(stub, domainName)
+	if err != nil {
+		return err
+	}
+	err = clearOperators(stub, domainName)
+	if err != nil {
+		return err
+	}
+	address := row.Columns[domainIPColumn].GetString_()
+	ipRow, err := stub.GetRow("IPToName", stringKey(address))
+	if err == nil && len(ipRow.Columns) != 0 && ipRow.Columns[1].GetString_() == domainName {
+		err = stub.DeleteRow("IPToName", stringKey(address))
+		if err != nil {
+			return newError(CodeInternal, "Error deleting row: %s", err)
+		}
+	}
+
+	accountRow, err := getAccountRow(stub, owner)
+	if err != nil {
+		return err
+	}
+	err = replaceAccountColumn(stub, accountRow, accountDomainsColumn,
+		removeFromList(accountRow.Columns[accountDomainsColumn].GetString_(), domainName))
+	if err != nil {
+		return err
+	}
+
+	err = addStat(stub, statsKindTotal, statsDomains, -1)
+	if err != nil {
+		return err
+	}
+	if day, ok := expiryDay(row); ok {
+		err = addStat(stub, statsKindExpiring, day, -1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"testing"
+	"time"
+)
+
+func (r *testRegistry) domain(domainName string) Domain {
+	var domain Domain
+	r.query("getDomain", []string{domainName}, &domain)
+	return domain
+}
+
+func (r *testRegistry) stats() RegistryStats {
+	var stats RegistryStats
+	r.query("query_stats", []string{"400"}, &stats)
+	return stats
+}
+
+func TestDomainDates(t *testing.T) {
+	r := newAuctionRegistry(t)
+	registered := r.now
+	r.mustInvoke("registerDomain", "alice", "example.com", "10.0.0.1", "365")
+	domain := r.domain("example.com")
+	if domain.Registered != registered.Format(timeFormat) || domain.DurationDays != "365" ||
+		domain.Expiry != registered.AddDate(0, 0, 365).Format(timeFormat) {
+		t.Fatalf("Unexpected registration: %+v", domain)
+	}
+
+	r.now = r.now.Add(time.Hour)
+	r.mustInvoke("renewDomain", "alice", "example.com", "30")
+	domain = r.domain("example.com")
+	if domain.Registered != registered.Format(timeFormat) || domain.DurationDays != "395" ||
+		domain.Expiry != registered.AddDate(0, 0, 395).Format(timeFormat) {
+		t.Fatalf("Unexpected renewal: %+v", domain)
+	}
+	if code := r.invoke("renewDomain", "alice", "example.com", "1.5"); code != CodeInvalidArgument {
+		t.Fatalf("Fractional renewal: expected %s, got %q", CodeInvalidArgument, code)
+	}
+
+	if code := r.invoke("placeBid", "bob", "alice", "example.com", "12.5"); code != CodeInvalidArgument {
+		t.Fatalf("Fractional bid: expected %s, got %q", CodeInvalidArgument, code)
+	}
+	r.mustInvoke("placeBid", "bob", "alice", "example.com", "12")
+	r.now = r.now.Add(time.Hour)
+	transferred := r.now
+	r.mustInvoke("transferDomain", "alice", "example.com", "bob", "10.0.0.2")
+	domain = r.domain("example.com")
+	if domain.Owner != "bob" || domain.Registered != transferred.Format(timeFormat) || domain.DurationDays != "395" ||
+		domain.Expiry != transferred.AddDate(0, 0, 395).Format(timeFormat) {
+		t.Fatalf("Unexpected transfer: %+v", domain)
+	}
+
+	stats := r.stats()
+	if stats.Domains != 1 || stats.AcceptedBids != 1 || stats.OpenBids != 0 ||
+		stats.RegistrationsPerDay[registered.Format(statsDayFormat)] != 1 || stats.ExpiringDomains != 1 {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestDomainLockSignaturesCannotBeReplayed(t *testing.T) {
+	r := newAuctionRegistry(t)
+	r.mustInvoke("registerDomain", "alice", "example.com", "10.0.0.1", "365")
+	r.mustInvoke("lockDomain", "alice", "example.com", StatusTransferProhibited)
+	request := r.signedArgs("requestUnlock", "alice", "example.com", StatusTransferProhibited)
+	if code := r.invokeArgs("requestUnlock", request); code != "" {
+		t.Fatalf("Unlock request failed: %s", code)
+	}
+	r.now = r.now.Add(unlockDelay)
+	confirm := r.signedArgs("confirmUnlock", "alice", "example.com")
+	if code := r.invokeArgs("confirmUnlock", confirm); code != "" {
+		t.Fatalf("Unlock failed: %s", code)
+	}
+
+	// the owner locks the domain again; the captured unlock cannot undo it
+	r.mustInvoke("lockDomain", "alice", "example.com", StatusTransferProhibited)
+	if code := r.invokeArgs("requestUnlock", request); code != CodeUnauthorized {
+		t.Fatalf("Replayed unlock request: expected %s, got %q", CodeUnauthorized, code)
+	}
+	r.now = r.now.Add(unlockDelay)
+	if code := r.invokeArgs("confirmUnlock", confirm); code != CodeUnauthorized {
+		t.Fatalf("Replayed unlock: expected %s, got %q", CodeUnauthorized, code)
+	}
+	if status := r.domain("example.com").Status; len(status) != 1 || status[0] != StatusTransferProhibited {
+		t.Fatalf("Unexpected status %v", status)
+	}
+}
+
+func TestDomainLockTiming(t *testing.T) {
+	r := newAuctionRegistry(t)
+	r.mustInvoke("registerDomain", "alice", "example.com", "10.0.0.1", "365")
+	r.mustInvoke("lockDomain", "alice", "example.com", StatusTransferProhibited+","+StatusUpdateProhibited)
+	if code := r.invoke("updateDomain", "alice", "example.com", "10.0.0.2"); code != CodeConflict {
+		t.Fatalf("Update of a locked domain: expected %s, got %q", CodeConflict, code)
+	}
+	r.mustInvoke("placeBid", "bob", "alice", "example.com", "10")
+	if code := r.invoke("transferDomain", "alice", "example.com", "bob", "10.0.0.2"); code != CodeConflict {
+		t.Fatalf("Transfer of a locked domain: expected %s, got %q", CodeConflict, code)
+	}
+	if code := r.invoke("requestUnlock", "alice", "example.com", StatusDeleteProhibited); code != CodeConflict {
+		t.Fatalf("Unlock of a flag that is not set: expected %s, got %q", CodeConflict, code)
+	}
+	if code := r.invoke("confirmUnlock", "alice", "example.com"); code != CodeConflict {
+		t.Fatalf("Confirm without a request: expected %s, got %q", CodeConflict, code)
+	}
+
+	r.mustInvoke("requestUnlock", "alice", "example.com", StatusUpdateProhibited)
+	domain := r.domain("example.com")
+	if len(domain.PendingUnlock) != 1 || domain.PendingUnlock[0] != StatusUpdateProhibited ||
+		domain.UnlockAt != r.now.Add(unlockDelay).Format(timeFormat) {
+		t.Fatalf("Unexpected unlock request: %+v", domain)
+	}
+	r.now = r.now.Add(unlockDelay - time.Hour)
+	if code := r.invoke("confirmUnlock", "alice", "example.com"); code != CodeConflict {
+		t.Fatalf("Confirm before the delay: expected %s, got %q", CodeConflict, code)
+	}
+
+	// locking the flag again cancels the request
+	r.mustInvoke("lockDomain", "alice", "example.com", StatusUpdateProhibited)
+	if domain := r.domain("example.com"); len(domain.PendingUnlock) != 0 || domain.UnlockAt != "" {
+		t.Fatalf("Unlock request not cancelled: %+v", domain)
+	}
+	r.now = r.now.Add(2 * time.Hour)
+	if code := r.invoke("confirmUnlock", "alice", "example.com"); code != CodeConflict {
+		t.Fatalf("Confirm of a cancelled request: expected %s, got %q", CodeConflict, code)
+	}
+
+	r.mustInvoke("requestUnlock", "alice", "example.com", StatusUpdateProhibited)
+	r.now = r.now.Add(unlockDelay)
+	r.mustInvoke("confirmUnlock", "alice", "example.com")
+	if status := r.domain("example.com").Status; len(status) != 1 || status[0] != StatusTransferProhibited {
+		t.Fatalf("Unexpected status %v", status)
+	}
+	r.mustInvoke("updateDomain", "alice", "example.com", "10.0.0.2")
+	if code := r.invoke("transferDomain", "alice", "example.com", "bob", "10.0.0.3"); code != CodeConflict {
+		t.Fatalf("Transfer of a domain still locked: expected %s, got %q", CodeConflict, code)
+	}
+}
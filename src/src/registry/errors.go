@@ -0,0 +1,64 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Error codes carried in the "code" field of every error returned by Query
+// and Invoke. Clients should branch on these, never on the message.
+const (
+	CodeNotFound        = "NOT_FOUND"
+	CodeUnauthorized    = "UNAUTHORIZED"
+	CodeConflict        = "CONFLICT"
+	CodeInvalidArgument = "INVALID_ARGUMENT"
+	CodeExpired         = "EXPIRED"
+	CodeInternal        = "INTERNAL"
+)
+
+// ChaincodeError is the error schema shared by every chaincode function.
+// Error() returns the JSON encoding, which is what the peer hands back to
+// the client, e.g. {"code":"NOT_FOUND","message":"Domain is not registered"}
+type ChaincodeError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ChaincodeError) Error() string {
+	encoded, err := json.Marshal(e)
+	if err != nil {
+		// Two strings always marshal, this is only here to be safe
+		return fmt.Sprintf("{\"code\":%q,\"message\":%q}", e.Code, e.Message)
+	}
+	return string(encoded)
+}
+
+// newError builds a ChaincodeError with a formatted message
+func newError(code string, format string, args ...interface{}) *ChaincodeError {
+	return &ChaincodeError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// asChaincodeError returns err as a ChaincodeError. Errors that are not one
+// already (shim and marshalling failures) are reported as INTERNAL.
+func asChaincodeError(err error) *ChaincodeError {
+	if ccErr, ok := err.(*ChaincodeError); ok {
+		return ccErr
+	}
+	return &ChaincodeError{Code: CodeInternal, Message: err.Error()}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// queryCode calls a query and returns the code of the error, if any
+func (r *testRegistry) queryCode(function string, args []string) string {
+	_, err := r.peer.Query(function, args, r.now)
+	if err == nil {
+		return ""
+	}
+	var chaincodeErr ChaincodeError
+	if json.Unmarshal([]byte(err.Error()), &chaincodeErr) != nil {
+		r.t.Fatalf("%s failed without a chaincode error: %s", function, err)
+	}
+	return chaincodeErr.Code
+}
+
+func TestChaincodeError(t *testing.T) {
+	err := newError(CodeNotFound, "Domain %s is not registered", "example.com")
+	if encoded := err.Error(); encoded != `{"code":"NOT_FOUND","message":"Domain example.com is not registered"}` {
+		t.Fatalf("Unexpected encoding %s", encoded)
+	}
+	if asChaincodeError(err) != err {
+		t.Fatalf("ChaincodeError was wrapped again")
+	}
+	if wrapped := asChaincodeError(errors.New("shim failure")); wrapped.Code != CodeInternal || wrapped.Message != "shim failure" {
+		t.Fatalf("Unexpected wrapped error %+v", wrapped)
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	r := newAuctionRegistry(t)
+	r.mustInvoke("registerDomain", "alice", "example.com", "10.0.0.1", "365")
+
+	if code := r.invoke("registerDomain", "bob", "example.com", "10.0.0.2", "365"); code != CodeConflict {
+		t.Fatalf("Registering a taken name: expected %s, got %q", CodeConflict, code)
+	}
+	if code := r.invoke("updateDomain", "bob", "example.com", "10.0.0.2"); code != CodeUnauthorized {
+		t.Fatalf("Update by another account: expected %s, got %q", CodeUnauthorized, code)
+	}
+	forged := r.signedArgs("updateDomain", "bob", "example.com", "10.0.0.2")
+	forged[0] = "alice"
+	if code := r.invokeArgs("updateDomain", forged); code != CodeUnauthorized {
+		t.Fatalf("Update signed by another key: expected %s, got %q", CodeUnauthorized, code)
+	}
+	if code := r.invoke("updateDomain", "alice", "example.org", "10.0.0.2"); code != CodeNotFound {
+		t.Fatalf("Update of a missing domain: expected %s, got %q", CodeNotFound, code)
+	}
+	if code := r.invokeArgs("noSuchFunction", nil); code != CodeInvalidArgument {
+		t.Fatalf("Unknown invoke: expected %s, got %q", CodeInvalidArgument, code)
+	}
+
+	if code := r.queryCode("getDomain", []string{"example.org"}); code != CodeNotFound {
+		t.Fatalf("Missing domain: expected %s, got %q", CodeNotFound, code)
+	}
+	if code := r.queryCode("noSuchFunction", nil); code != CodeInvalidArgument {
+		t.Fatalf("Unknown query: expected %s, got %q", CodeInvalidArgument, code)
+	}
+	if code := r.queryCode("getDomain", []string{"example.com"}); code != "" {
+		t.Fatalf("Query failed: %s", code)
+	}
+}
@@ -0,0 +1,216 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Every account has an escrow balance, in the smallest unit of whatever
+// currency the registry is paid in. Payments happen off the ledger: an
+// administrator deposits what an account paid in, and withdraw records a
+// payout. Auctions hold the amount of every live bid, so a bidder can never
+// bid more than it has deposited, and capture the price from the winner.
+const escrowTable = "Escrow"
+
+// columns of the Escrow table
+const (
+	escrowAccountColumn = iota
+	escrowAvailableColumn
+	escrowHeldColumn
+)
+
+// Balance is the payload returned by getBalance. stored and version tell
+// putBalance whether the Escrow row exists and which version it was read at.
+type Balance struct {
+	Available uint64 `json:"available"`
+	Held      uint64 `json:"held"`
+
+	stored  bool
+	version uint64
+}
+
+func createEscrowTable(stub *shim.ChaincodeStub) error {
+	return stub.CreateTable(escrowTable, []*shim.ColumnDefinition{
+		{"userEmail", shim.ColumnDefinition_STRING, true, false},
+		{"Available", shim.ColumnDefinition_UINT64, false, false},
+		{"Held", shim.ColumnDefinition_UINT64, false, false},
+	})
+}
+
+func parseAmount(value string) (uint64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	amount, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, newError(CodeInternal, "Invalid amount %q", value)
+	}
+	return amount, nil
+}
+
+// getBalanceOf returns the escrow balance of an account, zero if it never
+// had one
+func getBalanceOf(stub TableReader, userEmail string) (*Balance, error) {
+	row, err := stub.GetRow(escrowTable, stringKey(userEmail))
+	if err != nil {
+		return nil, newError(CodeInternal, "Error reading escrow of %s: %s", userEmail, err)
+	}
+	balance := &Balance{}
+	if len(row.Columns) == 0 {
+		return balance, nil
+	}
+	balance.Available = row.Columns[escrowAvailableColumn].GetUint64()
+	balance.Held = row.Columns[escrowHeldColumn].GetUint64()
+	balance.stored = true
+	balance.version = row.Version
+	return balance, nil
+}
+
+// putBalance writes a balance read with getBalanceOf back, unless the Escrow
+// row changed since it was read
+func putBalance(stub *shim.ChaincodeStub, userEmail string, balance *Balance) error {
+	row := shim.Row{
+		Columns: []*shim.Column{
+			{Value: &shim.Column_String_{String_: userEmail}},
+			{Value: &shim.Column_Uint64{Uint64: balance.Available}},
+			{Value: &shim.Column_Uint64{Uint64: balance.Held}},
+		},
+	}
+	var err error
+	if balance.stored {
+		_, err = stub.ReplaceRowIfVersion(escrowTable, row, balance.version)
+	} else {
+		_, err = stub.InsertRow(escrowTable, row)
+	}
+	if err == shim.ErrRowVersionMismatch {
+		return newError(CodeConflict, "The escrow of %s changed while it was being updated", userEmail)
+	}
+	if err != nil {
+		return newError(CodeInternal, "Error updating escrow of %s: %s", userEmail, err)
+	}
+	return nil
+}
+
+// checkNoEscrow is called by closeAccount: an account opened later under the
+// same name would otherwise get the funds
+func checkNoEscrow(stub *shim.ChaincodeStub, userEmail string) error {
+	balance, err := getBalanceOf(stub, userEmail)
+	if err != nil {
+		return err
+	}
+	if balance.Held != 0 {
+		return newError(CodeConflict, "%s has %d held in escrow by auctions", userEmail, balance.Held)
+	}
+	if balance.Available != 0 {
+		return newError(CodeConflict, "%s has %d available in escrow. Withdraw it before closing the account.", userEmail, balance.Available)
+	}
+	return nil
+}
+
+// holdFunds moves amount from the available to the held balance
+func holdFunds(stub *shim.ChaincodeStub, userEmail string, amount uint64) error {
+	balance, err := getBalanceOf(stub, userEmail)
+	if err != nil {
+		return err
+	}
+	if balance.Available < amount {
+		return newError(CodeConflict, "%s has %d available in escrow, %d needed", userEmail, balance.Available, amount)
+	}
+	balance.Available -= amount
+	balance.Held += amount
+	return putBalance(stub, userEmail, balance)
+}
+
+// releaseFunds moves amount from the held back to the available balance
+func releaseFunds(stub *shim.ChaincodeStub, userEmail string, amount uint64) error {
+	balance, err := getBalanceOf(stub, userEmail)
+	if err != nil {
+		return err
+	}
+	if balance.Held < amount {
+		return newError(CodeInternal, "%s has %d held in escrow, cannot release %d", userEmail, balance.Held, amount)
+	}
+	balance.Held -= amount
+	balance.Available += amount
+	return putBalance(stub, userEmail, balance)
+}
+
+// captureFunds takes amount out of the held balance for good
+func captureFunds(stub *shim.ChaincodeStub, userEmail string, amount uint64) error {
+	balance, err := getBalanceOf(stub, userEmail)
+	if err != nil {
+		return err
+	}
+	if balance.Held < amount {
+		return newError(CodeInternal, "%s has %d held in escrow, cannot capture %d", userEmail, balance.Held, amount)
+	}
+	balance.Held -= amount
+	return putBalance(stub, userEmail, balance)
+}
+
+// deposit credits an account with a payment received off the ledger
+//
+// args: account, signature, depositor, amount
+func (t *DNSChaincode) deposit(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	_, err := getAccountRow(stub, args[2])
+	if err != nil {
+		return nil, err
+	}
+	amount, _ := strconv.ParseUint(args[3], 10, 64)
+	balance, err := getBalanceOf(stub, args[2])
+	if err != nil {
+		return nil, err
+	}
+	if balance.Available+amount < balance.Available {
+		return nil, newError(CodeInvalidArgument, "Deposit of %d overflows the balance of %s", amount, args[2])
+	}
+	balance.Available += amount
+	return nil, putBalance(stub, args[2], balance)
+}
+
+// withdraw records a payout of available escrow funds
+//
+// args: account, signature, amount
+func (t *DNSChaincode) withdraw(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	amount, _ := strconv.ParseUint(args[2], 10, 64)
+	balance, err := getBalanceOf(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if balance.Available < amount {
+		return nil, newError(CodeConflict, "%s has %d available in escrow", args[0], balance.Available)
+	}
+	balance.Available -= amount
+	return nil, putBalance(stub, args[0], balance)
+}
+
+// getBalance returns the escrow balance of the calling account
+//
+// args: account, signature
+func (t *DNSChaincode) getBalance(stub *shim.ChaincodeStub, args []string) (*Balance, error) {
+	check, err := t.checkUserPrivKey(stub, args)
+	if err != nil {
+		return nil, err
+	}
+	if !check {
+		return nil, newError(CodeUnauthorized, "User private key can not be verified")
+	}
+	return getBalanceOf(stub, args[0])
+}
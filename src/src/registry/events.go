@@ -0,0 +1,86 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// A transaction carries at most one chaincode event, but one invoke can cause
+// several, e.g. a transfer completed by the invoke that starts another one.
+// The events of an invoke are therefore collected and set together, when the
+// invoke succeeds, as a single registryEventName event whose payload is the
+// JSON array of them.
+const registryEventName = "registry"
+
+// Event types
+const (
+	EventTransferPending   = "transferPending"
+	EventTransferCompleted = "transferCompleted"
+	EventTransferCancelled = "transferCancelled"
+	EventTransferFailed    = "transferFailed"
+	EventAuctionStarted    = "auctionStarted"
+	EventAuctionExtended   = "auctionExtended"
+	EventAuctionSettled    = "auctionSettled"
+)
+
+// RegistryEvent is one entry of the registry event payload
+type RegistryEvent struct {
+	Type   string `json:"type"`
+	Domain string `json:"domainName"`
+	Owner  string `json:"owner,omitempty"`
+	Buyer  string `json:"buyer,omitempty"`
+	Amount uint64 `json:"amount,omitempty"`
+	At     string `json:"at,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// pendingEvents holds the events of the invokes in progress, by transaction
+var pendingEvents = struct {
+	sync.Mutex
+	byTx map[string][]RegistryEvent
+}{byTx: make(map[string][]RegistryEvent)}
+
+func emitEvent(stub *shim.ChaincodeStub, event RegistryEvent) {
+	pendingEvents.Lock()
+	defer pendingEvents.Unlock()
+	pendingEvents.byTx[stub.UUID] = append(pendingEvents.byTx[stub.UUID], event)
+}
+
+func takeEvents(stub *shim.ChaincodeStub) []RegistryEvent {
+	pendingEvents.Lock()
+	defer pendingEvents.Unlock()
+	events := pendingEvents.byTx[stub.UUID]
+	delete(pendingEvents.byTx, stub.UUID)
+	return events
+}
+
+// flushEvents sets the events collected for the transaction
+func flushEvents(stub *shim.ChaincodeStub) error {
+	events := takeEvents(stub)
+	if len(events) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	return stub.SetEvent(registryEventName, payload)
+}
@@ -0,0 +1,495 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// The registry keeps the same facts in several places: a domain is in
+// NameToIP, in IPToName under its address and in the DomainOwned list of its
+// owner; an open transfer request is in TransferRequests and in the bid lists
+// of both accounts; and RegistryStats counts all of them. Older versions of
+// the chaincode did not always update every copy. CheckIntegrity compares
+// the copies and reports every disagreement as an IntegrityIssue, and the
+// repair invoke applies the fixes of the repairable ones.
+//
+// NameToIP and TransferRequests are taken as the truth. The copies are
+// rebuilt from them, never the other way around.
+
+// Issue kinds
+const (
+	IssueStaleAddress      = "staleAddress"         // IPToName row for an address no domain uses
+	IssueAddressMismatch   = "addressMismatch"      // IPToName row disagrees with NameToIP
+	IssueMissingAddress    = "missingAddress"       // domain without an IPToName row
+	IssueAddressConflict   = "addressConflict"      // two domains use the same address
+	IssueUnknownOwner      = "unknownOwner"         // domain owned by a missing account
+	IssueOrphanRequest     = "orphanRequest"        // open request of a missing account
+	IssueStaleRequest      = "staleRequest"         // open request for a domain the owner lost
+	IssueStaleTransfer     = "stalePendingTransfer" // pending transfer of a request no longer open
+	IssueDomainList        = "domainList"           // DomainOwned column is wrong
+	IssueRequestedBidsList = "requestedBidsList"    // RequestedBids column is wrong
+	IssueOwnedBidsList     = "ownedBidsList"        // OwnedBids column is wrong
+	IssueOrphanAccountRow  = "orphanAccountRow"     // per-account row of a missing account
+	IssueUnknownAdmin      = "unknownAdministrator" // administrator without an account
+	IssueCounter           = "counter"              // RegistryStats counter is off
+	IssueEscrowHeld        = "escrowHeld"           // held escrow disagrees with the open bids
+	IssueSkeletonIndex     = "skeletonIndex"        // DomainSkeletons row is wrong or missing
+)
+
+// TableReader is the part of the shim CheckIntegrity reads through.
+// *shim.ChaincodeStub implements it, and so can a reader over a copy of the
+// peer database.
+type TableReader interface {
+	GetState(key string) ([]byte, error)
+	GetRow(tableName string, key []shim.Column) (shim.Row, error)
+	GetRows(tableName string, key []shim.Column) (<-chan shim.Row, error)
+}
+
+// IntegrityIssue is one inconsistency. Key is the key of the offending row
+// in Table.
+type IntegrityIssue struct {
+	Kind       string `json:"kind"`
+	Table      string `json:"table"`
+	Key        string `json:"key"`
+	Detail     string `json:"detail"`
+	Repairable bool   `json:"repairable"`
+
+	fix func(stub *shim.ChaincodeStub) error
+}
+
+// IntegrityReport is the payload returned by checkIntegrity
+type IntegrityReport struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	Domains       int               `json:"domains"`
+	Addresses     int               `json:"addresses"`
+	Accounts      int               `json:"accounts"`
+	Requests      int               `json:"requests"`
+	Issues        []*IntegrityIssue `json:"issues"`
+}
+
+func (r *IntegrityReport) add(kind string, table string, key string, fix func(stub *shim.ChaincodeStub) error, format string, args ...interface{}) {
+	r.Issues = append(r.Issues, &IntegrityIssue{
+		Kind:       kind,
+		Table:      table,
+		Key:        key,
+		Detail:     fmt.Sprintf(format, args...),
+		Repairable: fix != nil,
+		fix:        fix,
+	})
+}
+
+// counterCheck is the value a RegistryStats counter should have
+type counterCheck struct {
+	kind     string
+	bucket   string
+	expected int64
+}
+
+func stringKey(value string) []shim.Column {
+	return []shim.Column{{Value: &shim.Column_String_{String_: value}}}
+}
+
+func readAllRows(tables TableReader, table string) ([]shim.Row, error) {
+	rowChan, err := tables.GetRows(table, []shim.Column{})
+	if err != nil {
+		return nil, newError(CodeInternal, "Error reading %s: %s", table, err)
+	}
+	var rows []shim.Row
+	for row := range rowChan {
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// addressRow builds the IPToName row of a NameToIP row
+func addressRow(domainRow shim.Row) shim.Row {
+	return shim.Row{
+		Columns: []*shim.Column{
+			{Value: &shim.Column_String_{String_: domainRow.Columns[domainIPColumn].GetString_()}},
+			{Value: &shim.Column_String_{String_: domainRow.Columns[domainNameColumn].GetString_()}},
+			{Value: &shim.Column_String_{String_: domainRow.Columns[domainOwnerColumn].GetString_()}},
+			domainRow.Columns[domainRegisteredColumn],
+			domainRow.Columns[domainDurationColumn],
+		},
+	}
+}
+
+// reconcileList rewrites a comma separated list column to hold exactly
+// expected. Entries already listed keep their order, duplicates and entries
+// not expected are dropped and returned as stale, and the missing ones are
+// appended.
+func reconcileList(list string, expected []string) (value string, stale []string, missing []string) {
+	want := make(map[string]bool)
+	for _, entry := range expected {
+		want[entry] = true
+	}
+	seen := make(map[string]bool)
+	var kept []string
+	for _, entry := range splitList(list) {
+		if want[entry] && !seen[entry] {
+			seen[entry] = true
+			kept = append(kept, entry)
+		} else {
+			stale = append(stale, entry)
+		}
+	}
+	for _, entry := range expected {
+		if !seen[entry] {
+			seen[entry] = true
+			missing = append(missing, entry)
+			kept = append(kept, entry)
+		}
+	}
+	return strings.Join(kept, ","), stale, missing
+}
+
+// CheckIntegrity reports every inconsistency between the registry tables.
+// The tables must be at the latest schema version.
+func CheckIntegrity(tables TableReader) (*IntegrityReport, error) {
+	version, err := readSchemaVersion(tables)
+	if err != nil {
+		return nil, err
+	}
+	if version != latestSchemaVersion {
+		return nil, newError(CodeConflict, "Registry tables are at schema version %d, only version %d can be checked", version, latestSchemaVersion)
+	}
+	report := &IntegrityReport{SchemaVersion: version, Issues: []*IntegrityIssue{}}
+
+	domainRows, err := readAllRows(tables, "NameToIP")
+	if err != nil {
+		return nil, err
+	}
+	addressRows, err := readAllRows(tables, "IPToName")
+	if err != nil {
+		return nil, err
+	}
+	accountRows, err := readAllRows(tables, "RegisteredUsers")
+	if err != nil {
+		return nil, err
+	}
+	requestRows, err := readAllRows(tables, "TransferRequests")
+	if err != nil {
+		return nil, err
+	}
+	report.Domains = len(domainRows)
+	report.Addresses = len(addressRows)
+	report.Accounts = len(accountRows)
+	report.Requests = len(requestRows)
+
+	domains := make(map[string]shim.Row)
+	for _, row := range domainRows {
+		domains[row.Columns[domainNameColumn].GetString_()] = row
+	}
+	accounts := make(map[string]bool)
+	for _, row := range accountRows {
+		accounts[row.Columns[accountEmailColumn].GetString_()] = true
+	}
+
+	// IPToName first, so that stale rows are deleted before the missing ones
+	// are inserted in their place
+	addresses := make(map[string]bool)
+	for _, row := range addressRows {
+		address := row.Columns[0].GetString_()
+		domainName := row.Columns[1].GetString_()
+		domainRow, ok := domains[domainName]
+		if !ok || domainRow.Columns[domainIPColumn].GetString_() != address {
+			report.add(IssueStaleAddress, "IPToName", address, func(stub *shim.ChaincodeStub) error {
+				return stub.DeleteRow("IPToName", stringKey(address))
+			}, "%s maps to %s, which does not use it", address, domainName)
+			continue
+		}
+		addresses[address] = true
+		expected := addressRow(domainRow)
+		for i, column := range expected.Columns {
+			if row.Columns[i].String() != column.String() {
+				report.add(IssueAddressMismatch, "IPToName", address, func(stub *shim.ChaincodeStub) error {
+					_, err := stub.ReplaceRow("IPToName", expected)
+					return err
+				}, "%s disagrees with NameToIP on %s", address, domainName)
+				break
+			}
+		}
+	}
+
+	domainsByOwner := make(map[string][]string)
+	claimed := make(map[string]string)
+	for _, row := range domainRows {
+		domainName := row.Columns[domainNameColumn].GetString_()
+		address := row.Columns[domainIPColumn].GetString_()
+		owner := row.Columns[domainOwnerColumn].GetString_()
+
+		if other, ok := claimed[address]; ok {
+			report.add(IssueAddressConflict, "NameToIP", domainName, nil,
+				"%s uses %s, which %s uses as well", domainName, address, other)
+		} else {
+			claimed[address] = domainName
+			if !addresses[address] {
+				expected := addressRow(row)
+				report.add(IssueMissingAddress, "NameToIP", domainName, func(stub *shim.ChaincodeStub) error {
+					_, err := stub.InsertRow("IPToName", expected)
+					return err
+				}, "%s has no IPToName row for %s", domainName, address)
+			}
+		}
+
+		if !accounts[owner] {
+			report.add(IssueUnknownOwner, "NameToIP", domainName, nil,
+				"%s is owned by %s, which does not exist", domainName, owner)
+			continue
+		}
+		domainsByOwner[owner] = append(domainsByOwner[owner], domainName)
+	}
+
+	// Open requests are listed by both accounts. Requests of a missing
+	// account are deleted, requests for a domain the owner no longer owns are
+	// rejected.
+	requestedByOwner := make(map[string][]string)
+	ownedByBuyer := make(map[string][]string)
+	bidCounts := make(map[string]int64)
+	for _, row := range requestRows {
+		requestID := row.Columns[0].GetString_()
+		owner := row.Columns[1].GetString_()
+		buyer := row.Columns[2].GetString_()
+		status := row.Columns[4].GetString_()
+		domainName := row.Columns[7].GetString_()
+
+		if status != bidStatusOpen {
+			bidCounts[status]++
+			continue
+		}
+		if !accounts[owner] || !accounts[buyer] {
+			report.add(IssueOrphanRequest, "TransferRequests", requestID, func(stub *shim.ChaincodeStub) error {
+				return stub.DeleteRow("TransferRequests", stringKey(requestID))
+			}, "Open request %s from %s to %s names a missing account", requestID, buyer, owner)
+			continue
+		}
+		domainRow, ok := domains[domainName]
+		if !ok || domainRow.Columns[domainOwnerColumn].GetString_() != owner {
+			rejected := shim.Row{Columns: make([]*shim.Column, len(row.Columns))}
+			copy(rejected.Columns, row.Columns)
+			rejected.Columns[4] = &shim.Column{Value: &shim.Column_String_{String_: bidStatusRejected}}
+			report.add(IssueStaleRequest, "TransferRequests", requestID, func(stub *shim.ChaincodeStub) error {
+				now, err := txTime(stub)
+				if err != nil {
+					return err
+				}
+				rejected.Columns[6] = dateColumn(now)
+				_, err = stub.ReplaceRow("TransferRequests", rejected)
+				return err
+			}, "Open request %s is for %s, which %s does not own", requestID, domainName, owner)
+			bidCounts[bidStatusRejected]++
+			continue
+		}
+		bidCounts[bidStatusOpen]++
+		requestedByOwner[owner] = append(requestedByOwner[owner], requestID)
+		ownedByBuyer[buyer] = append(ownedByBuyer[buyer], requestID)
+	}
+
+	requests := make(map[string]shim.Row)
+	for _, row := range requestRows {
+		requests[row.Columns[0].GetString_()] = row
+	}
+	pendingRows, err := readAllRows(tables, pendingTransfersTable)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range pendingRows {
+		domainName := row.Columns[pendingDomainColumn].GetString_()
+		requestID := row.Columns[pendingRequestColumn].GetString_()
+		request, ok := requests[requestID]
+		domainRow, known := domains[domainName]
+		if ok && known && request.Columns[4].GetString_() == bidStatusOpen && request.Columns[7].GetString_() == domainName &&
+			request.Columns[1].GetString_() == domainRow.Columns[domainOwnerColumn].GetString_() {
+			continue
+		}
+		pendingRow := row
+		report.add(IssueStaleTransfer, pendingTransfersTable, domainName, func(stub *shim.ChaincodeStub) error {
+			return deletePendingTransfer(stub, pendingRow)
+		}, "Pending transfer of %s is for request %s, which is not open for it", domainName, requestID)
+	}
+
+	lists := []struct {
+		kind     string
+		column   int
+		expected map[string][]string
+	}{
+		{IssueDomainList, accountDomainsColumn, domainsByOwner},
+		{IssueRequestedBidsList, accountRequestedBidsColumn, requestedByOwner},
+		{IssueOwnedBidsList, accountOwnedBidsColumn, ownedByBuyer},
+	}
+	for _, row := range accountRows {
+		userEmail := row.Columns[accountEmailColumn].GetString_()
+		for _, list := range lists {
+			value, stale, missing := reconcileList(row.Columns[list.column].GetString_(), list.expected[userEmail])
+			if len(stale) == 0 && len(missing) == 0 {
+				continue
+			}
+			column := list.column
+			report.add(list.kind, "RegisteredUsers", userEmail, func(stub *shim.ChaincodeStub) error {
+				accountRow, err := getAccountRow(stub, userEmail)
+				if err != nil {
+					return err
+				}
+				return replaceAccountColumn(stub, accountRow, column, value)
+			}, "Stale entries %v, missing entries %v", stale, missing)
+		}
+	}
+
+	for _, table := range []string{recoveryTable, keyAlgorithmTable} {
+		rows, err := readAllRows(tables, table)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			userEmail := row.Columns[0].GetString_()
+			if accounts[userEmail] {
+				continue
+			}
+			table := table
+			report.add(IssueOrphanAccountRow, table, userEmail, func(stub *shim.ChaincodeStub) error {
+				return stub.DeleteRow(table, stringKey(userEmail))
+			}, "Row of %s, which does not exist", userEmail)
+		}
+	}
+
+	// An administrator whose account was closed can be dropped as long as
+	// another one is left
+	adminRows, err := readAllRows(tables, administratorsTable)
+	if err != nil {
+		return nil, err
+	}
+	var orphans []string
+	for _, row := range adminRows {
+		if userEmail := row.Columns[0].GetString_(); !accounts[userEmail] {
+			orphans = append(orphans, userEmail)
+		}
+	}
+	for _, userEmail := range orphans {
+		userEmail := userEmail
+		var fix func(stub *shim.ChaincodeStub) error
+		if len(orphans) < len(adminRows) {
+			fix = func(stub *shim.ChaincodeStub) error {
+				return stub.DeleteRow(administratorsTable, stringKey(userEmail))
+			}
+		}
+		report.add(IssueUnknownAdmin, administratorsTable, userEmail, fix,
+			"Administrator %s does not exist", userEmail)
+	}
+
+	err = checkEscrow(tables, report)
+	if err != nil {
+		return nil, err
+	}
+	err = checkSkeletons(tables, domains, report)
+	if err != nil {
+		return nil, err
+	}
+
+	// The counters are compared with the tables as they will be once the
+	// issues above are repaired
+	expiring := make(map[string]int64)
+	for _, row := range domainRows {
+		if day, ok := expiryDay(row); ok {
+			expiring[day]++
+		}
+	}
+	counters := []counterCheck{
+		{statsKindTotal, statsDomains, int64(len(domainRows))},
+		{statsKindTotal, statsAccounts, int64(len(accountRows))},
+		{statsKindTotal, statsBidsOpen, bidCounts[bidStatusOpen]},
+		{statsKindTotal, statsBidsAccepted, bidCounts[bidStatusAccepted]},
+		{statsKindTotal, statsBidsRejected, bidCounts[bidStatusRejected]},
+	}
+	rowChan, err := tables.GetRows(statsTable, []shim.Column{{Value: &shim.Column_String_{String_: statsKindExpiring}}})
+	if err != nil {
+		return nil, newError(CodeInternal, "Error reading %s: %s", statsTable, err)
+	}
+	for row := range rowChan {
+		day := row.Columns[1].GetString_()
+		if _, ok := expiring[day]; !ok {
+			expiring[day] = 0
+		}
+	}
+	var days []string
+	for day := range expiring {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+	for _, day := range days {
+		counters = append(counters, counterCheck{statsKindExpiring, day, expiring[day]})
+	}
+	for _, counter := range counters {
+		recorded, err := getStat(tables, counter.kind, counter.bucket)
+		if err != nil {
+			return nil, newError(CodeInternal, "Error reading counter %s/%s: %s", counter.kind, counter.bucket, err)
+		}
+		if recorded == counter.expected {
+			continue
+		}
+		kind, bucket, delta := counter.kind, counter.bucket, counter.expected-recorded
+		report.add(IssueCounter, statsTable, kind+"/"+bucket, func(stub *shim.ChaincodeStub) error {
+			return addStat(stub, kind, bucket, delta)
+		}, "Counter is %d, expected %d", recorded, counter.expected)
+	}
+
+	return report, nil
+}
+
+// checkIntegrity reports every inconsistency between the registry tables
+//
+// args: account, signature
+func (t *DNSChaincode) checkIntegrity(stub *shim.ChaincodeStub) (*IntegrityReport, error) {
+	return CheckIntegrity(stub)
+}
+
+// repair fixes the repairable inconsistencies reported by checkIntegrity, or
+// only those of the given kinds. Issues that cannot be repaired, such as two
+// domains sharing an address, are left to the administrators.
+//
+// args: account, signature, kinds (optional, comma separated)
+func (t *DNSChaincode) repair(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	report, err := CheckIntegrity(stub)
+	if err != nil {
+		return nil, err
+	}
+	kinds := make(map[string]bool)
+	if len(args) > 2 {
+		for _, kind := range splitList(args[2]) {
+			kinds[kind] = true
+		}
+	}
+
+	repaired := 0
+	for _, issue := range report.Issues {
+		if !issue.Repairable || (len(kinds) != 0 && !kinds[issue.Kind]) {
+			continue
+		}
+		err = issue.fix(stub)
+		if err != nil {
+			return nil, newError(CodeInternal, "Repairing %s %s/%s failed: %s", issue.Kind, issue.Table, issue.Key, err)
+		}
+		repaired++
+	}
+	fmt.Println("Repaired " + strconv.Itoa(repaired) + " of " + strconv.Itoa(len(report.Issues)) + " issues")
+	return nil, nil
+}
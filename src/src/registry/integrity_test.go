@@ -0,0 +1,90 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// rowStateKey is the state key the shim stores the row of a table with a
+// single STRING key column under
+func rowStateKey(table string, key string) string {
+	return strconv.Itoa(len(table)) + table + strconv.Itoa(len(key)) + key
+}
+
+// issueKinds runs checkIntegrity and returns the kinds of the issues found,
+// sorted
+func (r *testRegistry) issueKinds() []string {
+	var report IntegrityReport
+	r.query("checkIntegrity", r.signedArgs("checkIntegrity", testAdmin), &report)
+	kinds := []string{}
+	for _, issue := range report.Issues {
+		kinds = append(kinds, issue.Kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+func TestCheckAndRepairIntegrity(t *testing.T) {
+	r := newTestRegistry(t)
+	r.createAccount("alice")
+	r.mustInvoke("registerDomain", "alice", "example.com", "10.0.0.1", "365")
+	r.mustInvoke("registerDomain", "alice", "example.org", "10.0.0.2", "365")
+	if kinds := r.issueKinds(); len(kinds) != 0 {
+		t.Fatalf("Unexpected issues %v", kinds)
+	}
+
+	// lose the address of one domain and the NameToIP row of the other
+	delete(r.peer.State, rowStateKey("IPToName", "10.0.0.1"))
+	delete(r.peer.State, rowStateKey("NameToIP", "example.org"))
+	found := make(map[string]bool)
+	for _, kind := range r.issueKinds() {
+		found[kind] = true
+	}
+	for _, kind := range []string{IssueMissingAddress, IssueStaleAddress, IssueDomainList, IssueCounter} {
+		if !found[kind] {
+			t.Fatalf("Expected a %s issue, got %v", kind, r.issueKinds())
+		}
+	}
+
+	if code := r.invoke("repair", "alice"); code != CodeUnauthorized {
+		t.Fatalf("Repair by another account: expected %s, got %q", CodeUnauthorized, code)
+	}
+	if _, err := r.peer.Query("checkIntegrity", r.signedArgs("checkIntegrity", "alice"), r.now); err == nil {
+		t.Fatalf("Integrity check by another account succeeded")
+	}
+
+	r.mustInvoke("repair", testAdmin, IssueMissingAddress)
+	for _, kind := range r.issueKinds() {
+		if kind == IssueMissingAddress {
+			t.Fatalf("%s not repaired", kind)
+		}
+	}
+	if _, err := r.peer.Query("getDomainName", []string{"10.0.0.1"}, r.now); err != nil {
+		t.Fatalf("Repaired address not found: %s", err)
+	}
+
+	r.mustInvoke("repair", testAdmin)
+	if kinds := r.issueKinds(); len(kinds) != 0 {
+		t.Fatalf("Unexpected issues after repair %v", kinds)
+	}
+	if code := r.queryCode("getDomainName", []string{"10.0.0.2"}); code != CodeNotFound {
+		t.Fatalf("Stale address: expected %s, got %q", CodeNotFound, code)
+	}
+}
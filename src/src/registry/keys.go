@@ -0,0 +1,288 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"golang.org/x/crypto/ed25519"
+)
+
+// Signature algorithms an account key can use. The algorithm of each account
+// is recorded in the AccountKeyAlgorithms table; accounts without a row there
+// were created before algorithms were recorded and use AlgRSAPKCS1v15.
+const (
+	AlgRSAPKCS1v15 = "rsa-pkcs1v15"
+	AlgRSAPSS      = "rsa-pss"
+	AlgECDSAP256   = "ecdsa-p256"
+	AlgECDSAP384   = "ecdsa-p384"
+	AlgEd25519     = "ed25519"
+)
+
+const keyAlgorithmTable = "AccountKeyAlgorithms"
+
+// Unsigner verifies a signature made with the private half of a public key
+type Unsigner interface {
+	Unsign(data []byte, sig []byte) error
+}
+
+// unsignerFactories builds the Unsigner of every supported algorithm from a
+// parsed PKIX public key
+var unsignerFactories = map[string]func(key interface{}) (Unsigner, error){
+	AlgRSAPKCS1v15: func(key interface{}) (Unsigner, error) {
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.New("not an RSA key")
+		}
+		return &rsaPublicKey{rsaKey}, nil
+	},
+	AlgRSAPSS: func(key interface{}) (Unsigner, error) {
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.New("not an RSA key")
+		}
+		return &rsaPSSPublicKey{rsaKey}, nil
+	},
+	AlgECDSAP256: func(key interface{}) (Unsigner, error) {
+		return newECDSAUnsigner(key, elliptic.P256(), crypto.SHA256)
+	},
+	AlgECDSAP384: func(key interface{}) (Unsigner, error) {
+		return newECDSAUnsigner(key, elliptic.P384(), crypto.SHA384)
+	},
+	AlgEd25519: func(key interface{}) (Unsigner, error) {
+		edKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return nil, errors.New("not an Ed25519 key")
+		}
+		return ed25519PublicKey(edKey), nil
+	},
+}
+
+// isKeyAlgorithm tells if algorithm is supported
+func isKeyAlgorithm(algorithm string) bool {
+	_, ok := unsignerFactories[algorithm]
+	return ok
+}
+
+func digest(hash crypto.Hash, message []byte) []byte {
+	h := hash.New()
+	h.Write(message)
+	return h.Sum(nil)
+}
+
+// rsaPublicKey verifies RSA PKCS#1 v1.5 signatures over SHA-256
+type rsaPublicKey struct {
+	*rsa.PublicKey
+}
+
+func (r *rsaPublicKey) Unsign(message []byte, sig []byte) error {
+	return rsa.VerifyPKCS1v15(r.PublicKey, crypto.SHA256, digest(crypto.SHA256, message), sig)
+}
+
+// rsaPSSPublicKey verifies RSA-PSS signatures over SHA-256
+type rsaPSSPublicKey struct {
+	*rsa.PublicKey
+}
+
+func (r *rsaPSSPublicKey) Unsign(message []byte, sig []byte) error {
+	return rsa.VerifyPSS(r.PublicKey, crypto.SHA256, digest(crypto.SHA256, message), sig, nil)
+}
+
+// ecdsaPublicKey verifies ASN.1 encoded ECDSA signatures, the same encoding
+// the fabric uses for its own ECDSA signatures
+type ecdsaPublicKey struct {
+	*ecdsa.PublicKey
+	hash crypto.Hash
+}
+
+func newECDSAUnsigner(key interface{}, curve elliptic.Curve, hash crypto.Hash) (Unsigner, error) {
+	ecKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an ECDSA key")
+	}
+	if ecKey.Curve != curve {
+		return nil, errors.New("key is on curve " + ecKey.Curve.Params().Name + ", expecting " + curve.Params().Name)
+	}
+	return &ecdsaPublicKey{ecKey, hash}, nil
+}
+
+func (e *ecdsaPublicKey) Unsign(message []byte, sig []byte) error {
+	signature := new(primitives.ECDSASignature)
+	rest, err := asn1.Unmarshal(sig, signature)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 || signature.R == nil || signature.S == nil {
+		return errors.New("malformed ECDSA signature")
+	}
+	if !ecdsa.Verify(e.PublicKey, digest(e.hash, message), signature.R, signature.S) {
+		return errors.New("ECDSA verification failure")
+	}
+	return nil
+}
+
+// ed25519PublicKey verifies Ed25519 signatures over the raw message
+type ed25519PublicKey ed25519.PublicKey
+
+func (e ed25519PublicKey) Unsign(message []byte, sig []byte) error {
+	if !ed25519.Verify(ed25519.PublicKey(e), message, sig) {
+		return errors.New("Ed25519 verification failure")
+	}
+	return nil
+}
+
+// defaultKeyAlgorithm picks the algorithm for a key whose algorithm was not
+// given, such as a recovery key
+func defaultKeyAlgorithm(key interface{}) string {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return AlgRSAPKCS1v15
+	case *ecdsa.PublicKey:
+		if k.Curve == elliptic.P384() {
+			return AlgECDSAP384
+		}
+		return AlgECDSAP256
+	case ed25519.PublicKey:
+		return AlgEd25519
+	}
+	return ""
+}
+
+func (t *DNSChaincode) newUnsignerFromKey(k interface{}, algorithm string) (Unsigner, error) {
+	if algorithm == "" {
+		algorithm = defaultKeyAlgorithm(k)
+	}
+	factory, ok := unsignerFactories[algorithm]
+	if !ok {
+		return nil, newError(CodeInvalidArgument, "Unsupported key type %T", k)
+	}
+	unsigner, err := factory(k)
+	if err != nil {
+		return nil, newError(CodeInvalidArgument, "Key cannot be used with %s: %s", algorithm, err)
+	}
+	return unsigner, nil
+}
+
+// decodePublicKey returns the PEM bytes of a public key given either as PEM
+// text or hex encoded PEM
+func decodePublicKey(key string) ([]byte, error) {
+	if strings.HasPrefix(strings.TrimSpace(key), "-----BEGIN") {
+		return []byte(key), nil
+	}
+	return hex.DecodeString(key)
+}
+
+// oidEd25519 identifies Ed25519 keys in PKIX, see RFC 8410
+var oidEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+// pkixPublicKey is the SubjectPublicKeyInfo of a PKIX public key
+type pkixPublicKey struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// parsePKIXPublicKey parses a PEM encoded PKIX public key. Ed25519 keys are
+// parsed here, the x509 package of the Go releases the fabric builds with
+// does not know them.
+func parsePKIXPublicKey(pemBytes []byte) (interface{}, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, newError(CodeInvalidArgument, "No PEM encoded public key found")
+	}
+	if block.Type != "PUBLIC KEY" {
+		return nil, newError(CodeInvalidArgument, "Unsupported PEM block %q, expecting \"PUBLIC KEY\"", block.Type)
+	}
+
+	var info pkixPublicKey
+	rest, err := asn1.Unmarshal(block.Bytes, &info)
+	if err == nil && len(rest) == 0 && info.Algorithm.Algorithm.Equal(oidEd25519) {
+		if len(info.PublicKey.Bytes) != ed25519.PublicKeySize {
+			return nil, newError(CodeInvalidArgument, "Ed25519 public key has %d bytes, expecting %d", len(info.PublicKey.Bytes), ed25519.PublicKeySize)
+		}
+		return ed25519.PublicKey(info.PublicKey.Bytes), nil
+	}
+
+	rawkey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, newError(CodeInvalidArgument, "%s", err)
+	}
+	return rawkey, nil
+}
+
+// parsePublicKey parses a PEM encoded PKIX public key for the given
+// algorithm. An empty algorithm picks the default for the key type.
+func (t *DNSChaincode) parsePublicKey(pemBytes []byte, algorithm string) (Unsigner, error) {
+	rawkey, err := parsePKIXPublicKey(pemBytes)
+	if err != nil {
+		return nil, err
+	}
+	return t.newUnsignerFromKey(rawkey, algorithm)
+}
+
+func createKeyAlgorithmTable(stub *shim.ChaincodeStub) error {
+	return stub.CreateTable(keyAlgorithmTable, []*shim.ColumnDefinition{
+		{"userEmail", shim.ColumnDefinition_STRING, true, false},
+		{"Algorithm", shim.ColumnDefinition_STRING, false, false},
+	})
+}
+
+// getKeyAlgorithm returns the algorithm of an account key
+func getKeyAlgorithm(stub *shim.ChaincodeStub, userEmail string) (string, error) {
+	row, err := stub.GetRow(keyAlgorithmTable, []shim.Column{{Value: &shim.Column_String_{String_: userEmail}}})
+	if err != nil {
+		return "", err
+	}
+	if len(row.Columns) == 0 {
+		return AlgRSAPKCS1v15, nil
+	}
+	return row.Columns[1].GetString_(), nil
+}
+
+// setKeyAlgorithm records the algorithm of an account key
+func setKeyAlgorithm(stub *shim.ChaincodeStub, userEmail string, algorithm string) error {
+	row := shim.Row{
+		Columns: []*shim.Column{
+			{Value: &shim.Column_String_{String_: userEmail}},
+			{Value: &shim.Column_String_{String_: algorithm}},
+		},
+	}
+	existing, err := stub.GetRow(keyAlgorithmTable, []shim.Column{{Value: &shim.Column_String_{String_: userEmail}}})
+	if err != nil {
+		return err
+	}
+	if len(existing.Columns) == 0 {
+		_, err = stub.InsertRow(keyAlgorithmTable, row)
+	} else {
+		_, err = stub.ReplaceRow(keyAlgorithmTable, row)
+	}
+	if err != nil {
+		return newError(CodeInternal, "Error recording key algorithm: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"strconv"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"golang.org/x/crypto/ed25519"
+)
+
+// testKey is a key pair of one of the supported algorithms
+type testKey struct {
+	algorithm string
+	publicKey string
+	sign      func(message []byte) []byte
+}
+
+func pemPublicKey(t *testing.T, key interface{}) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatalf("Error encoding key: %s", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func newTestKeys(t *testing.T) []testKey {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+	rsaSign := func(pss bool) func([]byte) []byte {
+		return func(message []byte) []byte {
+			var sig []byte
+			var err error
+			if pss {
+				sig, err = rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA256, digest(crypto.SHA256, message), nil)
+			} else {
+				sig, err = rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest(crypto.SHA256, message))
+			}
+			if err != nil {
+				t.Fatalf("Error signing: %s", err)
+			}
+			return sig
+		}
+	}
+	ecdsaKey := func(curve elliptic.Curve, hash crypto.Hash) (string, func([]byte) []byte) {
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatalf("Error generating key: %s", err)
+		}
+		return pemPublicKey(t, &key.PublicKey), func(message []byte) []byte {
+			r, s, err := ecdsa.Sign(rand.Reader, key, digest(hash, message))
+			if err != nil {
+				t.Fatalf("Error signing: %s", err)
+			}
+			sig, err := asn1.Marshal(primitives.ECDSASignature{R: r, S: s})
+			if err != nil {
+				t.Fatalf("Error encoding signature: %s", err)
+			}
+			return sig
+		}
+	}
+	p256, p256Sign := ecdsaKey(elliptic.P256(), crypto.SHA256)
+	p384, p384Sign := ecdsaKey(elliptic.P384(), crypto.SHA384)
+
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+
+	return []testKey{
+		{AlgRSAPKCS1v15, pemPublicKey(t, &rsaKey.PublicKey), rsaSign(false)},
+		{AlgRSAPSS, pemPublicKey(t, &rsaKey.PublicKey), rsaSign(true)},
+		{AlgECDSAP256, p256, p256Sign},
+		{AlgECDSAP384, p384, p384Sign},
+		{AlgEd25519, ed25519PEM(t, edPublic), func(message []byte) []byte { return ed25519.Sign(edPrivate, message) }},
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	cc := new(DNSChaincode)
+	for _, key := range newTestKeys(t) {
+		signature := hex.EncodeToString(key.sign([]byte("message")))
+		for _, publicKey := range []string{key.publicKey, hex.EncodeToString([]byte(key.publicKey))} {
+			valid, err := cc.verifySignature(publicKey, key.algorithm, []byte("message"), signature)
+			if err != nil || !valid {
+				t.Fatalf("%s signature rejected: %t, %v", key.algorithm, valid, err)
+			}
+		}
+		valid, err := cc.verifySignature(key.publicKey, key.algorithm, []byte("other message"), signature)
+		if err != nil || valid {
+			t.Fatalf("%s signature accepted for another message: %t, %v", key.algorithm, valid, err)
+		}
+	}
+}
+
+func TestKeyAlgorithmMismatch(t *testing.T) {
+	cc := new(DNSChaincode)
+	keys := newTestKeys(t)
+	for _, c := range []struct {
+		key       testKey
+		algorithm string
+	}{
+		{keys[0], AlgECDSAP256},
+		{keys[2], AlgECDSAP384},
+		{keys[3], AlgRSAPSS},
+		{keys[4], AlgECDSAP256},
+		{keys[2], "dsa"},
+	} {
+		_, err := cc.parsePublicKey([]byte(c.key.publicKey), c.algorithm)
+		if err == nil || asChaincodeError(err).Code != CodeInvalidArgument {
+			t.Fatalf("%s key used with %s: expected %s, got %v", c.key.algorithm, c.algorithm, CodeInvalidArgument, err)
+		}
+	}
+	for _, key := range keys {
+		if key.algorithm == AlgRSAPSS {
+			continue
+		}
+		rawKey, err := parsePKIXPublicKey([]byte(key.publicKey))
+		if err != nil {
+			t.Fatalf("Error parsing %s key: %s", key.algorithm, err)
+		}
+		if algorithm := defaultKeyAlgorithm(rawKey); algorithm != key.algorithm {
+			t.Fatalf("Expected %s as the default algorithm, got %s", key.algorithm, algorithm)
+		}
+	}
+}
+
+func TestAccountKeyAlgorithms(t *testing.T) {
+	r := newTestRegistry(t)
+	keys := newTestKeys(t)
+	for i, key := range keys {
+		account := key.algorithm + "@example.com"
+		if _, err := r.peer.Invoke("createAccount", []string{account, "", key.publicKey, "password", key.algorithm}, r.now); err != nil {
+			t.Fatalf("Error creating %s account: %s", key.algorithm, err)
+		}
+		signature := hex.EncodeToString(key.sign([]byte(account)))
+		if code := r.invokeArgs("registerDomain", []string{account, signature, key.algorithm + ".com", "10.0.0." + strconv.Itoa(i+1), "365"}); code != "" {
+			t.Fatalf("Call signed with %s failed: %s", key.algorithm, code)
+		}
+	}
+
+	// the account records its algorithm, another padding of the same RSA
+	// key does not sign for it
+	account := AlgRSAPSS + "@example.com"
+	signature := hex.EncodeToString(keys[0].sign([]byte(account)))
+	if code := r.invokeArgs("registerDomain", []string{account, signature, "pss.com", "10.0.1.1", "365"}); code != CodeUnauthorized {
+		t.Fatalf("PKCS#1 v1.5 signature for an RSA-PSS account: expected %s, got %q", CodeUnauthorized, code)
+	}
+}
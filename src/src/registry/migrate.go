@@ -0,0 +1,600 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"encoding/hex"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// The layout of the registry tables is versioned. The schemaVersion state key
+// holds the version the tables were written with; tables that predate the key
+// are version 0. Init stamps the latest version only when it creates the
+// tables itself. Tables inherited from an older chaincode, for instance by
+// deploying with copyStateFrom, keep their version until an administrator
+// runs migrate, and every other invoke is refused until then.
+//
+// Migrations are applied in order and each one moves the tables up by exactly
+// one version. A migration must keep working against the layout of the
+// version before it, so never change one once it has shipped; add a new one.
+const schemaVersionKey = "schemaVersion"
+
+type migration struct {
+	description string
+	apply       func(stub *shim.ChaincodeStub) error
+}
+
+// migrations[i] upgrades the tables from version i to version i+1
+var migrations = []migration{
+	{"Add Expiry and TTL columns to NameToIP", addDomainExpiryAndTTL},
+	{"Add Status and unlock columns to NameToIP", addDomainStatus},
+	{"Index the skeletons of registered domains", indexDomainSkeletons},
+	{"Index TransferRequests by Owner", indexTransferRequestOwners},
+	{"Store escrow, auction and counter values in typed columns", useTypedColumns},
+	{"Index pending transfers by completion time", indexPendingTransfers},
+	{"Add a claim deadline to Auctions", addAuctionClaimDeadline},
+	{"Store domain, transfer request and account dates, durations and bid values in typed columns", useTypedDomainColumns},
+	{"Index Auctions by Winner", indexAuctionWinners},
+	{"Store unlock request and pending transfer times in typed columns", useTypedUnlockAndTransferDates},
+}
+
+// latestSchemaVersion is the version of the layout Init creates
+var latestSchemaVersion = len(migrations)
+
+// SchemaVersion is the payload returned by getSchemaVersion
+type SchemaVersion struct {
+	Version int      `json:"version"`
+	Latest  int      `json:"latest"`
+	Pending []string `json:"pending"`
+}
+
+func readSchemaVersion(stub TableReader) (int, error) {
+	value, err := stub.GetState(schemaVersionKey)
+	if err != nil {
+		return 0, newError(CodeInternal, "Error reading schema version: %s", err)
+	}
+	if value == nil {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(string(value))
+	if err != nil {
+		return 0, newError(CodeInternal, "Invalid schema version %q", value)
+	}
+	return version, nil
+}
+
+func writeSchemaVersion(stub *shim.ChaincodeStub, version int) error {
+	err := stub.PutState(schemaVersionKey, []byte(strconv.Itoa(version)))
+	if err != nil {
+		return newError(CodeInternal, "Error writing schema version: %s", err)
+	}
+	return nil
+}
+
+// checkSchemaVersion fails unless the tables are at the latest version
+func checkSchemaVersion(stub TableReader) error {
+	version, err := readSchemaVersion(stub)
+	if err != nil {
+		return err
+	}
+	if version < latestSchemaVersion {
+		return newError(CodeConflict, "Registry tables are at schema version %d, an administrator must migrate them to version %d", version, latestSchemaVersion)
+	}
+	if version > latestSchemaVersion {
+		return newError(CodeConflict, "Registry tables are at schema version %d, newer than this chaincode (%d)", version, latestSchemaVersion)
+	}
+	return nil
+}
+
+// migrate applies the pending migrations, up to args[2] if given
+//
+// args: account, signature, targetVersion
+func (t *DNSChaincode) migrate(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	version, err := readSchemaVersion(stub)
+	if err != nil {
+		return nil, err
+	}
+	target := latestSchemaVersion
+	if len(args) > 2 {
+		target, err = strconv.Atoi(args[2])
+		if err != nil || target > latestSchemaVersion {
+			return nil, newError(CodeInvalidArgument, "Unknown schema version %s", args[2])
+		}
+	}
+	if target < version {
+		return nil, newError(CodeInvalidArgument, "Cannot migrate from schema version %d down to %d", version, target)
+	}
+
+	for ; version < target; version++ {
+		step := migrations[version]
+		err = step.apply(stub)
+		if err != nil {
+			return nil, newError(CodeInternal, "Migration to schema version %d (%s) failed: %s", version+1, step.description, err)
+		}
+		err = writeSchemaVersion(stub, version+1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// getSchemaVersion returns the current and latest schema versions and the
+// migrations still to be applied
+func (t *DNSChaincode) getSchemaVersion(stub *shim.ChaincodeStub) (*SchemaVersion, error) {
+	version, err := readSchemaVersion(stub)
+	if err != nil {
+		return nil, err
+	}
+	result := &SchemaVersion{Version: version, Latest: latestSchemaVersion, Pending: []string{}}
+	for i := version; i < latestSchemaVersion; i++ {
+		result.Pending = append(result.Pending, migrations[i].description)
+	}
+	return result, nil
+}
+
+// copyStateAllowedKey is the state key the fabric reads before copying the
+// registry into a chaincode deployed with copyStateFrom by someone other than
+// the deployer of the registry
+const copyStateAllowedKey = "fabric.copyStateAllowed"
+
+// allowStateCopy lets chaincodes deployed by anyone copy the registry tables
+// with copyStateFrom, or stops them again. Deployments signed with the
+// certificate that deployed the registry can always copy it.
+//
+// args: account, signature, allowed (true or false)
+func (t *DNSChaincode) allowStateCopy(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	allowed, err := strconv.ParseBool(args[2])
+	if err != nil {
+		return nil, newError(CodeInvalidArgument, "Expecting true or false, got %s", args[2])
+	}
+	if !allowed {
+		err = stub.DelState(copyStateAllowedKey)
+	} else {
+		err = stub.PutState(copyStateAllowedKey, []byte("true"))
+	}
+	if err != nil {
+		return nil, newError(CodeInternal, "Error writing %s: %s", copyStateAllowedKey, err)
+	}
+	return nil, nil
+}
+
+// rewriteTable recreates a table with new column definitions and inserts
+// every existing row again as returned by convert. The shim cannot change the
+// definition of a table that has rows, hence the delete and recreate; all of
+// it happens in the migrate transaction, so a failure leaves the table as it
+// was.
+func rewriteTable(stub *shim.ChaincodeStub, table string, columns []*shim.ColumnDefinition, convert func(row shim.Row) shim.Row) error {
+	return convertTable(stub, table, columns, func(row shim.Row) (shim.Row, error) {
+		return convert(row), nil
+	})
+}
+
+// convertTable is rewriteTable for conversions that can fail
+func convertTable(stub *shim.ChaincodeStub, table string, columns []*shim.ColumnDefinition, convert func(row shim.Row) (shim.Row, error)) error {
+	rowChan, err := stub.GetRows(table, []shim.Column{})
+	if err != nil {
+		return err
+	}
+	var rows []shim.Row
+	for row := range rowChan {
+		converted, err := convert(row)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, converted)
+	}
+
+	err = stub.DeleteTable(table)
+	if err != nil {
+		return err
+	}
+	err = stub.CreateTable(table, columns)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		_, err = stub.InsertRow(table, row)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addDomainExpiryAndTTL moves NameToIP to version 1. Expiry is computed from
+// the registration date and duration, TTL gets the default.
+func addDomainExpiryAndTTL(stub *shim.ChaincodeStub) error {
+	columns := []*shim.ColumnDefinition{
+		{"domainName", shim.ColumnDefinition_STRING, true, false},
+		{"ipAddress", shim.ColumnDefinition_STRING, false, false},
+		{"userEmail", shim.ColumnDefinition_STRING, false, false},
+		{"DateRegistered", shim.ColumnDefinition_STRING, false, false},
+		{"Duration", shim.ColumnDefinition_STRING, false, false},
+		{"Expiry", shim.ColumnDefinition_STRING, false, false},
+		{"TTL", shim.ColumnDefinition_STRING, false, false},
+	}
+	return rewriteTable(stub, "NameToIP", columns, func(row shim.Row) shim.Row {
+		expiry := ""
+		registered, err := parseTime(row.Columns[domainRegisteredColumn].GetString_())
+		days, daysErr := strconv.Atoi(row.Columns[domainDurationColumn].GetString_())
+		if err == nil && daysErr == nil {
+			expiry = registered.AddDate(0, 0, days).Format(timeFormat)
+		}
+		row.Columns = append(row.Columns,
+			&shim.Column{Value: &shim.Column_String_{String_: expiry}},
+			&shim.Column{Value: &shim.Column_String_{String_: defaultTTL}},
+		)
+		return row
+	})
+}
+
+// addDomainStatus moves NameToIP to version 2. Existing domains start without
+// any status flag.
+func addDomainStatus(stub *shim.ChaincodeStub) error {
+	columns := []*shim.ColumnDefinition{
+		{"domainName", shim.ColumnDefinition_STRING, true, false},
+		{"ipAddress", shim.ColumnDefinition_STRING, false, false},
+		{"userEmail", shim.ColumnDefinition_STRING, false, false},
+		{"DateRegistered", shim.ColumnDefinition_STRING, false, false},
+		{"Duration", shim.ColumnDefinition_STRING, false, false},
+		{"Expiry", shim.ColumnDefinition_STRING, false, false},
+		{"TTL", shim.ColumnDefinition_STRING, false, false},
+		{"Status", shim.ColumnDefinition_STRING, false, false},
+		{"PendingUnlock", shim.ColumnDefinition_STRING, false, false},
+		{"UnlockRequested", shim.ColumnDefinition_STRING, false, false},
+	}
+	return rewriteTable(stub, "NameToIP", columns, func(row shim.Row) shim.Row {
+		row.Columns = append(row.Columns,
+			&shim.Column{Value: &shim.Column_String_{String_: ""}},
+			&shim.Column{Value: &shim.Column_String_{String_: ""}},
+			&shim.Column{Value: &shim.Column_String_{String_: ""}},
+		)
+		return row
+	})
+}
+
+// indexTransferRequestOwners moves TransferRequests to version 4. Recreating
+// the table with Owner indexed builds the index of the existing requests.
+func indexTransferRequestOwners(stub *shim.ChaincodeStub) error {
+	columns := []*shim.ColumnDefinition{
+		{"RequestID", shim.ColumnDefinition_STRING, true, false},
+		{"Owner", shim.ColumnDefinition_STRING, false, true},
+		{"Buyer", shim.ColumnDefinition_STRING, false, false},
+		{"BidValue", shim.ColumnDefinition_STRING, false, false},
+		{"Status", shim.ColumnDefinition_STRING, false, false},
+		{"DateRequested", shim.ColumnDefinition_STRING, false, false},
+		{"DateDecision", shim.ColumnDefinition_STRING, false, false},
+		{"DomainName", shim.ColumnDefinition_STRING, false, false},
+	}
+	return rewriteTable(stub, "TransferRequests", columns, func(row shim.Row) shim.Row {
+		return row
+	})
+}
+
+// hasColumnType returns whether a column of a table is already of the given
+// type. Init creates missing tables with the latest layout, so a migration
+// may find some of its tables converted.
+func hasColumnType(stub *shim.ChaincodeStub, table string, column int, columnType shim.ColumnDefinition_Type) (bool, error) {
+	definition, err := stub.GetTable(table)
+	if err != nil {
+		return false, err
+	}
+	return definition.ColumnDefinitions[column].Type == columnType, nil
+}
+
+func amountColumnOf(row shim.Row, column int) (*shim.Column, error) {
+	amount, err := parseAmount(row.Columns[column].GetString_())
+	if err != nil {
+		return nil, err
+	}
+	return &shim.Column{Value: &shim.Column_Uint64{Uint64: amount}}, nil
+}
+
+// timeColumnOf converts an RFC 3339 time column to a Unix time, 0 if empty
+func timeColumnOf(row shim.Row, column int) (*shim.Column, error) {
+	value := row.Columns[column].GetString_()
+	if value == "" {
+		return &shim.Column{Value: &shim.Column_Int64{Int64: 0}}, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, newError(CodeInternal, "Invalid time %q", value)
+	}
+	return &shim.Column{Value: &shim.Column_Int64{Int64: parsed.Unix()}}, nil
+}
+
+// useTypedColumns moves Escrow, RegistryStats, Auctions and AuctionBids to
+// version 5. Amounts become UINT64 columns, counters INT64 and times Unix
+// times in INT64 columns. Sealed bid commitments are stored as bytes, and
+// the reveal time of a bid is split into a Revealed flag and RevealedAt.
+func useTypedColumns(stub *shim.ChaincodeStub) error {
+	return convertColumns(stub, []columnConversion{
+		{"Escrow", 1, shim.ColumnDefinition_UINT64, []*shim.ColumnDefinition{
+			{"userEmail", shim.ColumnDefinition_STRING, true, false},
+			{"Available", shim.ColumnDefinition_UINT64, false, false},
+			{"Held", shim.ColumnDefinition_UINT64, false, false},
+		}, func(row shim.Row) (shim.Row, error) {
+			var err error
+			for _, column := range []int{1, 2} {
+				row.Columns[column], err = amountColumnOf(row, column)
+				if err != nil {
+					return row, err
+				}
+			}
+			return row, nil
+		}},
+		{"RegistryStats", 2, shim.ColumnDefinition_INT64, []*shim.ColumnDefinition{
+			{"Kind", shim.ColumnDefinition_STRING, true, false},
+			{"Bucket", shim.ColumnDefinition_STRING, true, false},
+			{"Count", shim.ColumnDefinition_INT64, false, false},
+		}, func(row shim.Row) (shim.Row, error) {
+			count, err := strconv.ParseInt(row.Columns[2].GetString_(), 10, 64)
+			if err != nil {
+				return row, newError(CodeInternal, "Invalid counter %q", row.Columns[2].GetString_())
+			}
+			row.Columns[2] = &shim.Column{Value: &shim.Column_Int64{Int64: count}}
+			return row, nil
+		}},
+		{"Auctions", 3, shim.ColumnDefinition_UINT64, []*shim.ColumnDefinition{
+			{"domainName", shim.ColumnDefinition_STRING, true, false},
+			{"Kind", shim.ColumnDefinition_STRING, false, false},
+			{"Status", shim.ColumnDefinition_STRING, false, false},
+			{"Reserve", shim.ColumnDefinition_UINT64, false, false},
+			{"MinIncrement", shim.ColumnDefinition_UINT64, false, false},
+			{"Extension", shim.ColumnDefinition_UINT64, false, false},
+			{"CommitEnds", shim.ColumnDefinition_INT64, false, false},
+			{"Ends", shim.ColumnDefinition_INT64, false, false},
+			{"HighBidder", shim.ColumnDefinition_STRING, false, false},
+			{"HighBid", shim.ColumnDefinition_UINT64, false, false},
+			{"Winner", shim.ColumnDefinition_STRING, false, false},
+			{"Price", shim.ColumnDefinition_UINT64, false, false},
+		}, func(row shim.Row) (shim.Row, error) {
+			var err error
+			for _, column := range []int{3, 4, 5, 9, 11} {
+				row.Columns[column], err = amountColumnOf(row, column)
+				if err != nil {
+					return row, err
+				}
+			}
+			for _, column := range []int{6, 7} {
+				row.Columns[column], err = timeColumnOf(row, column)
+				if err != nil {
+					return row, err
+				}
+			}
+			return row, nil
+		}},
+		{"AuctionBids", 2, shim.ColumnDefinition_BYTES, []*shim.ColumnDefinition{
+			{"domainName", shim.ColumnDefinition_STRING, true, false},
+			{"Bidder", shim.ColumnDefinition_STRING, true, false},
+			{"Commitment", shim.ColumnDefinition_BYTES, false, false},
+			{"Amount", shim.ColumnDefinition_UINT64, false, false},
+			{"Revealed", shim.ColumnDefinition_BOOL, false, false},
+			{"RevealedAt", shim.ColumnDefinition_INT64, false, false},
+		}, func(row shim.Row) (shim.Row, error) {
+			commitment, err := hex.DecodeString(row.Columns[2].GetString_())
+			if err != nil {
+				return row, newError(CodeInternal, "Invalid commitment %q", row.Columns[2].GetString_())
+			}
+			amount, err := amountColumnOf(row, 3)
+			if err != nil {
+				return row, err
+			}
+			revealedAt, err := timeColumnOf(row, 4)
+			if err != nil {
+				return row, err
+			}
+			row.Columns = []*shim.Column{
+				row.Columns[0],
+				row.Columns[1],
+				{Value: &shim.Column_Bytes{Bytes: commitment}},
+				amount,
+				{Value: &shim.Column_Bool{Bool: row.Columns[4].GetString_() != ""}},
+				revealedAt,
+			}
+			return row, nil
+		}},
+	})
+}
+
+// columnConversion converts table to columns, unless column already has the
+// typed type
+type columnConversion struct {
+	table   string
+	column  int
+	typed   shim.ColumnDefinition_Type
+	columns []*shim.ColumnDefinition
+	convert func(row shim.Row) (shim.Row, error)
+}
+
+// convertColumns applies the conversions of the tables that need them
+func convertColumns(stub *shim.ChaincodeStub, conversions []columnConversion) error {
+	for _, conversion := range conversions {
+		typed, err := hasColumnType(stub, conversion.table, conversion.column, conversion.typed)
+		if err != nil {
+			return err
+		}
+		if typed {
+			continue
+		}
+		err = convertTable(stub, conversion.table, conversion.columns, conversion.convert)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dateColumnOf converts a date column written in timeFormat or
+// legacyTimeFormat to a dateColumn, no date if empty
+func dateColumnOf(row shim.Row, column int) (*shim.Column, error) {
+	value := row.Columns[column].GetString_()
+	if value == "" {
+		return dateColumn(time.Time{}), nil
+	}
+	parsed, err := parseTime(value)
+	if err != nil {
+		return nil, newError(CodeInternal, "Invalid date %q", value)
+	}
+	return dateColumn(parsed), nil
+}
+
+// daysColumnOf converts a duration column to a daysColumn
+func daysColumnOf(row shim.Row, column int) (*shim.Column, error) {
+	value := row.Columns[column].GetString_()
+	days, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return nil, newError(CodeInternal, "Invalid duration %q", value)
+	}
+	return daysColumn(days), nil
+}
+
+// useTypedDomainColumns moves NameToIP, IPToName, TransferRequests and
+// RegisteredUsers to version 8. Dates become dateColumns and durations
+// daysColumns; the Expiry of a domain is computed again from its
+// registration date and duration. Bid values were decimal numbers, but they
+// never moved funds, so they are rounded down to whole units like the
+// escrow amounts.
+func useTypedDomainColumns(stub *shim.ChaincodeStub) error {
+	return convertColumns(stub, []columnConversion{
+		{"NameToIP", domainRegisteredColumn, shim.ColumnDefinition_INT64, []*shim.ColumnDefinition{
+			{"domainName", shim.ColumnDefinition_STRING, true, false},
+			{"ipAddress", shim.ColumnDefinition_STRING, false, false},
+			{"userEmail", shim.ColumnDefinition_STRING, false, false},
+			{"DateRegistered", shim.ColumnDefinition_INT64, false, false},
+			{"Duration", shim.ColumnDefinition_UINT64, false, false},
+			{"Expiry", shim.ColumnDefinition_INT64, false, false},
+			{"TTL", shim.ColumnDefinition_STRING, false, false},
+			{"Status", shim.ColumnDefinition_STRING, false, false},
+			{"PendingUnlock", shim.ColumnDefinition_STRING, false, false},
+			{"UnlockRequested", shim.ColumnDefinition_STRING, false, false},
+		}, func(row shim.Row) (shim.Row, error) {
+			var err error
+			row.Columns[domainRegisteredColumn], err = dateColumnOf(row, domainRegisteredColumn)
+			if err != nil {
+				return row, err
+			}
+			row.Columns[domainDurationColumn], err = daysColumnOf(row, domainDurationColumn)
+			if err != nil {
+				return row, err
+			}
+			row.Columns[domainExpiryColumn] = expiryColumn(row.Columns[domainRegisteredColumn], row.Columns[domainDurationColumn].GetUint64())
+			return row, nil
+		}},
+		{"IPToName", 3, shim.ColumnDefinition_INT64, []*shim.ColumnDefinition{
+			{"ipAddress", shim.ColumnDefinition_STRING, true, false},
+			{"domainName", shim.ColumnDefinition_STRING, false, false},
+			{"userEmail", shim.ColumnDefinition_STRING, false, false},
+			{"DateRegistered", shim.ColumnDefinition_INT64, false, false},
+			{"Duration", shim.ColumnDefinition_UINT64, false, false},
+		}, func(row shim.Row) (shim.Row, error) {
+			var err error
+			row.Columns[3], err = dateColumnOf(row, 3)
+			if err != nil {
+				return row, err
+			}
+			row.Columns[4], err = daysColumnOf(row, 4)
+			return row, err
+		}},
+		{"TransferRequests", 3, shim.ColumnDefinition_UINT64, []*shim.ColumnDefinition{
+			{"RequestID", shim.ColumnDefinition_STRING, true, false},
+			{"Owner", shim.ColumnDefinition_STRING, false, true},
+			{"Buyer", shim.ColumnDefinition_STRING, false, false},
+			{"BidValue", shim.ColumnDefinition_UINT64, false, false},
+			{"Status", shim.ColumnDefinition_STRING, false, false},
+			{"DateRequested", shim.ColumnDefinition_INT64, false, false},
+			{"DateDecision", shim.ColumnDefinition_INT64, false, false},
+			{"DomainName", shim.ColumnDefinition_STRING, false, false},
+		}, func(row shim.Row) (shim.Row, error) {
+			value, err := strconv.ParseFloat(row.Columns[3].GetString_(), 64)
+			if err != nil || value < 0 || value >= math.MaxUint64 {
+				return row, newError(CodeInternal, "Invalid bid value %q", row.Columns[3].GetString_())
+			}
+			row.Columns[3] = amountColumn(uint64(value))
+			for _, column := range []int{5, 6} {
+				row.Columns[column], err = dateColumnOf(row, column)
+				if err != nil {
+					return row, err
+				}
+			}
+			return row, nil
+		}},
+		{"RegisteredUsers", accountRegistrationDateColumn, shim.ColumnDefinition_INT64, []*shim.ColumnDefinition{
+			{"userEmail", shim.ColumnDefinition_STRING, true, false},
+			{"PubKey", shim.ColumnDefinition_STRING, false, false},
+			{"Password", shim.ColumnDefinition_STRING, false, false},
+			{"RegistrationDate", shim.ColumnDefinition_INT64, false, false},
+			{"DomainOwned", shim.ColumnDefinition_STRING, false, false},
+			{"RequestedBids", shim.ColumnDefinition_STRING, false, false},
+			{"OwnedBids", shim.ColumnDefinition_STRING, false, false},
+		}, func(row shim.Row) (shim.Row, error) {
+			var err error
+			row.Columns[accountRegistrationDateColumn], err = dateColumnOf(row, accountRegistrationDateColumn)
+			return row, err
+		}},
+	})
+}
+
+// useTypedUnlockAndTransferDates moves NameToIP and PendingTransfers to
+// version 10: the time an unlock was requested and the start and completion
+// times of pending transfers become dateColumns. A completion time that
+// cannot be parsed made the transfer due at once; it becomes no date, which
+// still does, so the due keys stay as they are.
+func useTypedUnlockAndTransferDates(stub *shim.ChaincodeStub) error {
+	return convertColumns(stub, []columnConversion{
+		{"NameToIP", domainUnlockRequestedColumn, shim.ColumnDefinition_INT64, []*shim.ColumnDefinition{
+			{"domainName", shim.ColumnDefinition_STRING, true, false},
+			{"ipAddress", shim.ColumnDefinition_STRING, false, false},
+			{"userEmail", shim.ColumnDefinition_STRING, false, false},
+			{"DateRegistered", shim.ColumnDefinition_INT64, false, false},
+			{"Duration", shim.ColumnDefinition_UINT64, false, false},
+			{"Expiry", shim.ColumnDefinition_INT64, false, false},
+			{"TTL", shim.ColumnDefinition_STRING, false, false},
+			{"Status", shim.ColumnDefinition_STRING, false, false},
+			{"PendingUnlock", shim.ColumnDefinition_STRING, false, false},
+			{"UnlockRequested", shim.ColumnDefinition_INT64, false, false},
+		}, func(row shim.Row) (shim.Row, error) {
+			var err error
+			row.Columns[domainUnlockRequestedColumn], err = dateColumnOf(row, domainUnlockRequestedColumn)
+			return row, err
+		}},
+		{pendingTransfersTable, pendingStartedColumn, shim.ColumnDefinition_INT64, []*shim.ColumnDefinition{
+			{"domainName", shim.ColumnDefinition_STRING, true, false},
+			{"RequestID", shim.ColumnDefinition_STRING, false, false},
+			{"Buyer", shim.ColumnDefinition_STRING, false, false},
+			{"NewIP", shim.ColumnDefinition_STRING, false, false},
+			{"Started", shim.ColumnDefinition_INT64, false, false},
+			{"CompletesAt", shim.ColumnDefinition_INT64, false, false},
+		}, func(row shim.Row) (shim.Row, error) {
+			var err error
+			row.Columns[pendingStartedColumn], err = dateColumnOf(row, pendingStartedColumn)
+			if err != nil {
+				return row, err
+			}
+			row.Columns[pendingCompletesColumn], err = dateColumnOf(row, pendingCompletesColumn)
+			if err != nil {
+				row.Columns[pendingCompletesColumn] = dateColumn(time.Time{})
+			}
+			return row, nil
+		}},
+	})
+}
@@ -0,0 +1,334 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/shimtest"
+)
+
+// stubChaincode runs the function set by the test in every transaction, so
+// that migrations can be run against tables laid out by the test
+type stubChaincode struct {
+	run func(stub *shim.ChaincodeStub) error
+}
+
+func (c *stubChaincode) Init(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	return nil, c.run(stub)
+}
+
+func (c *stubChaincode) Invoke(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	return nil, c.run(stub)
+}
+
+func (c *stubChaincode) Query(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	return nil, c.run(stub)
+}
+
+// createStringTable creates table with a STRING column for each name, the
+// first one being the key, and inserts rows into it
+func createStringTable(stub *shim.ChaincodeStub, table string, names []string, rows ...[]string) error {
+	columns := make([]*shim.ColumnDefinition, len(names))
+	for i, name := range names {
+		columns[i] = &shim.ColumnDefinition{Name: name, Type: shim.ColumnDefinition_STRING, Key: i == 0}
+	}
+	err := stub.CreateTable(table, columns)
+	if err != nil {
+		return err
+	}
+	for _, values := range rows {
+		row := shim.Row{}
+		for _, value := range values {
+			row.Columns = append(row.Columns, stringColumn(value))
+		}
+		_, err = stub.InsertRow(table, row)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestUseTypedDomainColumns(t *testing.T) {
+	registered := time.Date(2016, 10, 1, 12, 0, 0, 500, time.UTC)
+	requested := time.Date(2016, 10, 2, 8, 30, 0, 0, time.UTC)
+	cc := &stubChaincode{run: func(stub *shim.ChaincodeStub) error {
+		tables := []struct {
+			name    string
+			columns []string
+			row     []string
+		}{
+			{"NameToIP", []string{"domainName", "ipAddress", "userEmail", "DateRegistered", "Duration", "Expiry", "TTL", "Status", "PendingUnlock", "UnlockRequested"},
+				[]string{"example.com", "10.0.0.1", "alice", registered.Format(timeFormat), "365", "", "3600", "", "", ""}},
+			{"IPToName", []string{"ipAddress", "domainName", "userEmail", "DateRegistered", "Duration"},
+				[]string{"10.0.0.1", "example.com", "alice", registered.Format(timeFormat), "365"}},
+			{"TransferRequests", []string{"RequestID", "Owner", "Buyer", "BidValue", "Status", "DateRequested", "DateDecision", "DomainName"},
+				[]string{"abc123", "alice", "bob", "12.75", bidStatusOpen, requested.Format(time.RFC822), "", "example.com"}},
+			{"RegisteredUsers", []string{"userEmail", "PubKey", "Password", "RegistrationDate", "DomainOwned", "RequestedBids", "OwnedBids"},
+				[]string{"alice", "key", "password", registered.Format(timeFormat), "example.com", "", "abc123"}},
+		}
+		for _, table := range tables {
+			err := createStringTable(stub, table.name, table.columns, table.row)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}}
+	peer, err := shimtest.NewMockPeer("dns", cc)
+	if err != nil {
+		t.Fatalf("Error starting the chaincode: %s", err)
+	}
+	_, err = peer.Init("", nil, registered)
+	if err != nil {
+		t.Fatalf("Error creating the old tables: %s", err)
+	}
+
+	cc.run = useTypedDomainColumns
+	_, err = peer.Invoke("", nil, registered)
+	if err != nil {
+		t.Fatalf("Error migrating: %s", err)
+	}
+	// A second run finds the typed columns and leaves the tables alone
+	_, err = peer.Invoke("", nil, registered)
+	if err != nil {
+		t.Fatalf("Error migrating again: %s", err)
+	}
+
+	rows := make(map[string]shim.Row)
+	cc.run = func(stub *shim.ChaincodeStub) error {
+		for table, key := range map[string]string{"NameToIP": "example.com", "IPToName": "10.0.0.1", "TransferRequests": "abc123", "RegisteredUsers": "alice"} {
+			row, err := stub.GetRow(table, []shim.Column{*stringColumn(key)})
+			if err != nil {
+				return err
+			}
+			rows[table] = row
+		}
+		return nil
+	}
+	_, err = peer.Query("", nil, registered)
+	if err != nil {
+		t.Fatalf("Error reading the migrated tables: %s", err)
+	}
+
+	domain := rows["NameToIP"]
+	if date, ok := columnDate(domain.Columns[domainRegisteredColumn]); !ok || !date.Equal(registered) {
+		t.Fatalf("Unexpected registration date %v", domain.Columns[domainRegisteredColumn])
+	}
+	if domain.Columns[domainDurationColumn].GetUint64() != 365 {
+		t.Fatalf("Unexpected duration %v", domain.Columns[domainDurationColumn])
+	}
+	if date, ok := columnDate(domain.Columns[domainExpiryColumn]); !ok || !date.Equal(registered.AddDate(0, 0, 365)) {
+		t.Fatalf("Unexpected expiry %v", domain.Columns[domainExpiryColumn])
+	}
+	if domain.Columns[6].GetString_() != "3600" {
+		t.Fatalf("Unexpected TTL %v", domain.Columns[6])
+	}
+	address := rows["IPToName"]
+	if date, ok := columnDate(address.Columns[3]); !ok || !date.Equal(registered) || address.Columns[4].GetUint64() != 365 {
+		t.Fatalf("Unexpected address row %v", address.Columns)
+	}
+	request := rows["TransferRequests"]
+	if request.Columns[3].GetUint64() != 12 {
+		t.Fatalf("Unexpected bid value %v", request.Columns[3])
+	}
+	if date, ok := columnDate(request.Columns[5]); !ok || !date.Equal(requested) {
+		t.Fatalf("Unexpected request date %v", request.Columns[5])
+	}
+	if _, ok := columnDate(request.Columns[6]); ok {
+		t.Fatalf("Unexpected decision date %v", request.Columns[6])
+	}
+	if date, ok := columnDate(rows["RegisteredUsers"].Columns[accountRegistrationDateColumn]); !ok || !date.Equal(registered) {
+		t.Fatalf("Unexpected account registration date %v", rows["RegisteredUsers"].Columns[accountRegistrationDateColumn])
+	}
+}
+
+func TestUseTypedDomainColumnsInvalidDuration(t *testing.T) {
+	cc := &stubChaincode{run: func(stub *shim.ChaincodeStub) error {
+		return createStringTable(stub, "NameToIP",
+			[]string{"domainName", "ipAddress", "userEmail", "DateRegistered", "Duration", "Expiry", "TTL", "Status", "PendingUnlock", "UnlockRequested"},
+			[]string{"example.com", "10.0.0.1", "alice", "", "forever", "", "3600", "", "", ""})
+	}}
+	peer, err := shimtest.NewMockPeer("dns", cc)
+	if err != nil {
+		t.Fatalf("Error starting the chaincode: %s", err)
+	}
+	now := time.Date(2016, 10, 1, 12, 0, 0, 0, time.UTC)
+	_, err = peer.Init("", nil, now)
+	if err != nil {
+		t.Fatalf("Error creating the old table: %s", err)
+	}
+	cc.run = useTypedDomainColumns
+	if _, err = peer.Invoke("", nil, now); err == nil {
+		t.Fatalf("Migrated a domain with an invalid duration")
+	}
+}
+
+func TestUseTypedUnlockAndTransferDates(t *testing.T) {
+	registered := time.Date(2016, 10, 1, 12, 0, 0, 0, time.UTC)
+	requested := time.Date(2016, 10, 2, 8, 30, 0, 500, time.UTC)
+	completes := requested.AddDate(0, 0, 5)
+	cc := &stubChaincode{run: func(stub *shim.ChaincodeStub) error {
+		err := stub.CreateTable("NameToIP", []*shim.ColumnDefinition{
+			{"domainName", shim.ColumnDefinition_STRING, true, false},
+			{"ipAddress", shim.ColumnDefinition_STRING, false, false},
+			{"userEmail", shim.ColumnDefinition_STRING, false, false},
+			{"DateRegistered", shim.ColumnDefinition_INT64, false, false},
+			{"Duration", shim.ColumnDefinition_UINT64, false, false},
+			{"Expiry", shim.ColumnDefinition_INT64, false, false},
+			{"TTL", shim.ColumnDefinition_STRING, false, false},
+			{"Status", shim.ColumnDefinition_STRING, false, false},
+			{"PendingUnlock", shim.ColumnDefinition_STRING, false, false},
+			{"UnlockRequested", shim.ColumnDefinition_STRING, false, false},
+		})
+		if err != nil {
+			return err
+		}
+		for _, domain := range []struct{ name, requested string }{{"locked.com", requested.Format(timeFormat)}, {"open.com", ""}} {
+			_, err = stub.InsertRow("NameToIP", shim.Row{Columns: []*shim.Column{
+				stringColumn(domain.name), stringColumn("10.0.0.1"), stringColumn("alice"),
+				dateColumn(registered), daysColumn(365), dateColumn(expiryTime(registered, 365)),
+				stringColumn(defaultTTL), stringColumn(StatusTransferProhibited), stringColumn(StatusTransferProhibited),
+				stringColumn(domain.requested),
+			}})
+			if err != nil {
+				return err
+			}
+		}
+		return createStringTable(stub, pendingTransfersTable,
+			[]string{"domainName", "RequestID", "Buyer", "NewIP", "Started", "CompletesAt"},
+			[]string{"example.com", "abc123", "bob", "10.0.0.2", requested.Format(timeFormat), completes.Format(timeFormat)},
+			[]string{"broken.com", "def456", "bob", "10.0.0.3", requested.Format(timeFormat), "soon"})
+	}}
+	peer, err := shimtest.NewMockPeer("dns", cc)
+	if err != nil {
+		t.Fatalf("Error starting the chaincode: %s", err)
+	}
+	_, err = peer.Init("", nil, requested)
+	if err != nil {
+		t.Fatalf("Error creating the old tables: %s", err)
+	}
+	cc.run = useTypedUnlockAndTransferDates
+	for i := 0; i < 2; i++ {
+		_, err = peer.Invoke("", nil, requested)
+		if err != nil {
+			t.Fatalf("Error migrating: %s", err)
+		}
+	}
+
+	rows := make(map[string]shim.Row)
+	cc.run = func(stub *shim.ChaincodeStub) error {
+		for _, key := range []string{"locked.com", "open.com"} {
+			row, err := stub.GetRow("NameToIP", stringKey(key))
+			if err != nil {
+				return err
+			}
+			rows[key] = row
+		}
+		for _, key := range []string{"example.com", "broken.com"} {
+			row, err := stub.GetRow(pendingTransfersTable, stringKey(key))
+			if err != nil {
+				return err
+			}
+			rows[key] = row
+		}
+		return nil
+	}
+	_, err = peer.Query("", nil, requested)
+	if err != nil {
+		t.Fatalf("Error reading the migrated tables: %s", err)
+	}
+
+	if unlockAt := unlockTime(rows["locked.com"].Columns[domainUnlockRequestedColumn]); unlockAt != requested.Add(unlockDelay).Format(timeFormat) {
+		t.Fatalf("Unexpected unlock time %q", unlockAt)
+	}
+	if unlockAt := unlockTime(rows["open.com"].Columns[domainUnlockRequestedColumn]); unlockAt != "" {
+		t.Fatalf("Unexpected unlock time %q", unlockAt)
+	}
+	transfer := rows["example.com"]
+	if date, ok := columnDate(transfer.Columns[pendingStartedColumn]); !ok || !date.Equal(requested) {
+		t.Fatalf("Unexpected start %v", transfer.Columns[pendingStartedColumn])
+	}
+	if !pendingCompletesAt(transfer).Equal(completes) {
+		t.Fatalf("Unexpected completion %v", transfer.Columns[pendingCompletesColumn])
+	}
+	// the due key written before the migration still finds the transfer
+	if pendingDueKey(pendingCompletesAt(rows["broken.com"]), "broken.com") != pendingDueKey(time.Unix(0, 0), "broken.com") {
+		t.Fatalf("Unexpected completion %v", rows["broken.com"].Columns[pendingCompletesColumn])
+	}
+}
+
+func (r *testRegistry) schemaVersion() SchemaVersion {
+	var version SchemaVersion
+	r.query("getSchemaVersion", nil, &version)
+	return version
+}
+
+func TestMigrateOrder(t *testing.T) {
+	r := newTestRegistry(t)
+	r.createAccount("alice")
+	if version := r.schemaVersion(); version.Version != latestSchemaVersion || len(version.Pending) != 0 {
+		t.Fatalf("Unexpected version of new tables %+v", version)
+	}
+
+	// the last two migrations skip the tables Init created with their layout
+	old := latestSchemaVersion - 2
+	r.peer.State[schemaVersionKey] = []byte(strconv.Itoa(old))
+	pending := []string{migrations[old].description, migrations[old+1].description}
+	if version := r.schemaVersion(); version.Version != old || !reflect.DeepEqual(version.Pending, pending) {
+		t.Fatalf("Unexpected version %+v", version)
+	}
+	if code := r.invoke("registerDomain", "alice", "example.com", "10.0.0.1", "365"); code != CodeConflict {
+		t.Fatalf("Invoke before migrating: expected %s, got %q", CodeConflict, code)
+	}
+
+	if code := r.invoke("migrate", "alice"); code != CodeUnauthorized {
+		t.Fatalf("Migrate by another account: expected %s, got %q", CodeUnauthorized, code)
+	}
+	for _, target := range []int{latestSchemaVersion + 1, old - 1} {
+		if code := r.invoke("migrate", testAdmin, strconv.Itoa(target)); code != CodeInvalidArgument {
+			t.Fatalf("Migrate from %d to %d: expected %s, got %q", old, target, CodeInvalidArgument, code)
+		}
+	}
+	r.mustInvoke("migrate", testAdmin, strconv.Itoa(old+1))
+	if version := r.schemaVersion(); version.Version != old+1 || !reflect.DeepEqual(version.Pending, pending[1:]) {
+		t.Fatalf("Unexpected version after one migration %+v", version)
+	}
+	if code := r.invoke("registerDomain", "alice", "example.com", "10.0.0.1", "365"); code != CodeConflict {
+		t.Fatalf("Invoke with a migration pending: expected %s, got %q", CodeConflict, code)
+	}
+	r.mustInvoke("migrate", testAdmin)
+	if version := r.schemaVersion(); version.Version != latestSchemaVersion || len(version.Pending) != 0 {
+		t.Fatalf("Unexpected version after migrating %+v", version)
+	}
+	r.mustInvoke("registerDomain", "alice", "example.com", "10.0.0.1", "365")
+	r.mustInvoke("migrate", testAdmin)
+
+	// tables written by a newer chaincode are left alone
+	r.peer.State[schemaVersionKey] = []byte(strconv.Itoa(latestSchemaVersion + 1))
+	if code := r.invoke("renewDomain", "alice", "example.com", "1"); code != CodeConflict {
+		t.Fatalf("Invoke on newer tables: expected %s, got %q", CodeConflict, code)
+	}
+	if code := r.invoke("migrate", testAdmin); code != CodeInvalidArgument {
+		t.Fatalf("Migrate down: expected %s, got %q", CodeInvalidArgument, code)
+	}
+}
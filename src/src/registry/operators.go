@@ -0,0 +1,292 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// The owner of a domain can let other accounts, its operators, do part of
+// what it can with grantOperator. A grant lists the permissions it gives and
+// can run out after a number of days. Grants are kept in the DomainOperators
+// table and end with the ownership: a transfer or a release of the domain
+// drops them, and so does closing the account of the operator. Only the
+// owner can grant, revoke, transfer, delete or lock.
+const domainOperatorsTable = "DomainOperators"
+
+// Operator permissions
+const (
+	PermRecords = "records" // change the address and TTL with updateDomain
+	PermRenew   = "renew"   // extend the registration with renewDomain
+)
+
+// columns of the DomainOperators table
+const (
+	operatorDomainColumn = iota
+	operatorAccountColumn
+	operatorPermissionsColumn
+	operatorExpiresColumn
+	operatorGrantedColumn
+)
+
+// Operator is one entry of the payload returned by getOperators
+type Operator struct {
+	Account     string   `json:"account"`
+	Permissions []string `json:"permissions"`
+	Expires     string   `json:"expires,omitempty"`
+	Granted     string   `json:"granted"`
+}
+
+func createDomainOperatorsTable(stub *shim.ChaincodeStub) error {
+	return stub.CreateTable(domainOperatorsTable, []*shim.ColumnDefinition{
+		{"domainName", shim.ColumnDefinition_STRING, true, false},
+		{"Operator", shim.ColumnDefinition_STRING, true, false},
+		{"Permissions", shim.ColumnDefinition_STRING, false, false},
+		{"Expires", shim.ColumnDefinition_STRING, false, false},
+		{"Granted", shim.ColumnDefinition_STRING, false, false},
+	})
+}
+
+func isPermission(permission string) bool {
+	return permission == PermRecords || permission == PermRenew
+}
+
+func operatorKey(domainName string, operator string) []shim.Column {
+	return []shim.Column{
+		{Value: &shim.Column_String_{String_: domainName}},
+		{Value: &shim.Column_String_{String_: operator}},
+	}
+}
+
+// getManagedDomainRow returns the NameToIP row of a domain that caller owns,
+// or holds an unexpired grant of permission on
+func getManagedDomainRow(stub *shim.ChaincodeStub, caller string, domainName string, permission string) (shim.Row, error) {
+	row, err := stub.GetRow("NameToIP", stringKey(domainName))
+	if err != nil {
+		return row, newError(CodeInternal, "Error reading %s: %s", domainName, err)
+	}
+	if len(row.Columns) == 0 {
+		return row, newError(CodeNotFound, "Domain %s is not registered", domainName)
+	}
+	if row.Columns[domainOwnerColumn].GetString_() == caller {
+		return row, nil
+	}
+
+	grant, err := stub.GetRow(domainOperatorsTable, operatorKey(domainName, caller))
+	if err != nil {
+		return row, newError(CodeInternal, "Error reading operators: %s", err)
+	}
+	if len(grant.Columns) == 0 || !hasFlag(grant.Columns[operatorPermissionsColumn].GetString_(), permission) {
+		return row, newError(CodeUnauthorized, "%s neither owns %s nor may %s it", caller, domainName, permission)
+	}
+	if expires := grant.Columns[operatorExpiresColumn].GetString_(); expires != "" {
+		now, err := txTime(stub)
+		if err != nil {
+			return row, err
+		}
+		expiresAt, err := parseTime(expires)
+		if err != nil || !now.Before(expiresAt) {
+			return row, newError(CodeExpired, "The grant of %s on %s expired at %s", caller, domainName, expires)
+		}
+	}
+	return row, nil
+}
+
+// clearOperators drops every grant on a domain
+func clearOperators(stub *shim.ChaincodeStub, domainName string) error {
+	rowChan, err := stub.GetRows(domainOperatorsTable, stringKey(domainName))
+	if err != nil {
+		return newError(CodeInternal, "Error reading operators: %s", err)
+	}
+	var operators []string
+	for row := range rowChan {
+		operators = append(operators, row.Columns[operatorAccountColumn].GetString_())
+	}
+	for _, operator := range operators {
+		err = stub.DeleteRow(domainOperatorsTable, operatorKey(domainName, operator))
+		if err != nil {
+			return newError(CodeInternal, "Error deleting row: %s", err)
+		}
+	}
+	return nil
+}
+
+// clearGrantsTo drops every grant held by an account, so that an account
+// opened later under the same name does not inherit them. closeAccount calls
+// it; the table is read whole, since it is keyed by domain first and only
+// holds the grants on domains that have not changed hands since.
+func clearGrantsTo(stub *shim.ChaincodeStub, operator string) error {
+	rows, err := readAllRows(stub, domainOperatorsTable)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if row.Columns[operatorAccountColumn].GetString_() != operator {
+			continue
+		}
+		err = stub.DeleteRow(domainOperatorsTable, operatorKey(row.Columns[operatorDomainColumn].GetString_(), operator))
+		if err != nil {
+			return newError(CodeInternal, "Error deleting row: %s", err)
+		}
+	}
+	return nil
+}
+
+// grantOperator gives an account permissions on a domain, replacing any
+// earlier grant to it. A grant without days, or with 0, does not expire.
+//
+// args: account, signature, domainName, operator, permissions (comma
+// separated), days (optional)
+func (t *DNSChaincode) grantOperator(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	domainName := args[2]
+	operator := args[3]
+	_, err := getOwnedDomainRow(stub, args[0], domainName)
+	if err != nil {
+		return nil, err
+	}
+	if operator == args[0] {
+		return nil, newError(CodeInvalidArgument, "The owner cannot be an operator of its own domain")
+	}
+	_, err = getAccountRow(stub, operator)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	expires := ""
+	if len(args) > 5 {
+		days, _ := strconv.Atoi(args[5])
+		if days > 0 {
+			expires = now.AddDate(0, 0, days).Format(timeFormat)
+		}
+	}
+
+	row := shim.Row{
+		Columns: []*shim.Column{
+			{Value: &shim.Column_String_{String_: domainName}},
+			{Value: &shim.Column_String_{String_: operator}},
+			{Value: &shim.Column_String_{String_: strings.Join(splitList(args[4]), ",")}},
+			{Value: &shim.Column_String_{String_: expires}},
+			{Value: &shim.Column_String_{String_: now.Format(timeFormat)}},
+		},
+	}
+	inserted, err := stub.InsertRow(domainOperatorsTable, row)
+	if err == nil && !inserted {
+		_, err = stub.ReplaceRow(domainOperatorsTable, row)
+	}
+	if err != nil {
+		return nil, newError(CodeInternal, "Error writing grant: %s", err)
+	}
+	return nil, nil
+}
+
+// revokeOperator takes every permission of an operator away
+//
+// args: account, signature, domainName, operator
+func (t *DNSChaincode) revokeOperator(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	domainName := args[2]
+	_, err := getOwnedDomainRow(stub, args[0], domainName)
+	if err != nil {
+		return nil, err
+	}
+	key := operatorKey(domainName, args[3])
+	row, err := stub.GetRow(domainOperatorsTable, key)
+	if err != nil {
+		return nil, newError(CodeInternal, "Error reading operators: %s", err)
+	}
+	if len(row.Columns) == 0 {
+		return nil, newError(CodeNotFound, "%s is not an operator of %s", args[3], domainName)
+	}
+	err = stub.DeleteRow(domainOperatorsTable, key)
+	if err != nil {
+		return nil, newError(CodeInternal, "Error deleting row: %s", err)
+	}
+	return nil, nil
+}
+
+// renewDomain extends the registration of a domain by a number of days
+//
+// args: account, signature, domainName, days
+func (t *DNSChaincode) renewDomain(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	domainName := args[2]
+	row, err := getManagedDomainRow(stub, args[0], domainName, PermRenew)
+	if err != nil {
+		return nil, err
+	}
+	err = checkNoPendingTransfer(stub, domainName)
+	if err != nil {
+		return nil, err
+	}
+	days, _ := strconv.ParseUint(args[3], 10, 64)
+	if days == 0 {
+		return nil, newError(CodeInvalidArgument, "Renewal must be for at least one day")
+	}
+	oldDay, oldOk := expiryDay(row)
+	duration := row.Columns[domainDurationColumn].GetUint64() + days
+
+	err = replaceDomainColumns(stub, row, map[int]*shim.Column{
+		domainDurationColumn: daysColumn(duration),
+		domainExpiryColumn:   expiryColumn(row.Columns[domainRegisteredColumn], duration),
+	})
+	if err != nil {
+		return nil, err
+	}
+	row.Columns[domainDurationColumn] = daysColumn(duration)
+	_, err = stub.ReplaceRow("IPToName", addressRow(row))
+	if err != nil {
+		return nil, newError(CodeInternal, "Error updating row: %s", err)
+	}
+
+	if oldOk {
+		err = addStat(stub, statsKindExpiring, oldDay, -1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if day, ok := expiryDay(row); ok {
+		err = addStat(stub, statsKindExpiring, day, 1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// getOperators returns the grants on a domain, expired ones included
+//
+// args: domainName
+func (t *DNSChaincode) getOperators(stub *shim.ChaincodeStub, args []string) ([]*Operator, error) {
+	rowChan, err := stub.GetRows(domainOperatorsTable, stringKey(args[0]))
+	if err != nil {
+		return nil, newError(CodeInternal, "Error reading operators: %s", err)
+	}
+	operators := []*Operator{}
+	for row := range rowChan {
+		operators = append(operators, &Operator{
+			Account:     row.Columns[operatorAccountColumn].GetString_(),
+			Permissions: splitList(row.Columns[operatorPermissionsColumn].GetString_()),
+			Expires:     row.Columns[operatorExpiresColumn].GetString_(),
+			Granted:     row.Columns[operatorGrantedColumn].GetString_(),
+		})
+	}
+	return operators, nil
+}
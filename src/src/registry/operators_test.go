@@ -0,0 +1,174 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"testing"
+	"time"
+)
+
+func (r *testRegistry) operators(domainName string) []*Operator {
+	var operators []*Operator
+	r.query("getOperators", []string{domainName}, &operators)
+	return operators
+}
+
+func TestGrantCannotBeReplayed(t *testing.T) {
+	r := newAuctionRegistry(t)
+	r.mustInvoke("registerDomain", "alice", "example.com", "10.0.0.1", "365")
+	grant := r.signedArgs("grantOperator", "alice", "example.com", "bob", PermRecords)
+	if code := r.invokeArgs("grantOperator", grant); code != "" {
+		t.Fatalf("Grant failed: %s", code)
+	}
+	r.mustInvoke("revokeOperator", "alice", "example.com", "bob")
+	if code := r.invokeArgs("grantOperator", grant); code != CodeUnauthorized {
+		t.Fatalf("Replayed grant: expected %s, got %q", CodeUnauthorized, code)
+	}
+	if operators := r.operators("example.com"); len(operators) != 0 {
+		t.Fatalf("Unexpected operators %+v", operators)
+	}
+}
+
+func TestCloseAccountDropsGrants(t *testing.T) {
+	r := newTestRegistry(t)
+	for _, account := range []string{"alice", "bob", "carol"} {
+		r.createAccount(account)
+	}
+	r.mustInvoke("registerDomain", "alice", "example.com", "10.0.0.1", "365")
+	r.mustInvoke("registerDomain", "alice", "example.org", "10.0.0.2", "365")
+	r.mustInvoke("grantOperator", "alice", "example.com", "bob", PermRecords)
+	r.mustInvoke("grantOperator", "alice", "example.org", "bob", PermRenew)
+	r.mustInvoke("grantOperator", "alice", "example.org", "carol", PermRenew)
+
+	r.mustInvoke("closeAccount", "bob")
+	r.createAccount("bob")
+	if code := r.invoke("updateDomain", "bob", "example.com", "10.0.0.3"); code != CodeUnauthorized {
+		t.Fatalf("Update by a reopened account: expected %s, got %q", CodeUnauthorized, code)
+	}
+	if operators := r.operators("example.com"); len(operators) != 0 {
+		t.Fatalf("Unexpected operators %+v", operators)
+	}
+	if operators := r.operators("example.org"); len(operators) != 1 || operators[0].Account != "carol" {
+		t.Fatalf("Unexpected operators %+v", operators)
+	}
+}
+
+func TestOperatorPermissions(t *testing.T) {
+	r := newAuctionRegistry(t)
+	for _, account := range []string{"carol", "dave"} {
+		r.createAccount(account)
+	}
+	r.mustInvoke("registerDomain", "alice", "example.com", "10.0.0.1", "365")
+	if code := r.invoke("grantOperator", "alice", "example.com", "alice", PermRecords); code != CodeInvalidArgument {
+		t.Fatalf("Grant to the owner: expected %s, got %q", CodeInvalidArgument, code)
+	}
+	if code := r.invoke("grantOperator", "alice", "example.com", "bob", "transfer"); code != CodeInvalidArgument {
+		t.Fatalf("Unknown permission: expected %s, got %q", CodeInvalidArgument, code)
+	}
+	if code := r.invoke("grantOperator", "alice", "example.com", "erin", PermRecords); code != CodeNotFound {
+		t.Fatalf("Grant to a missing account: expected %s, got %q", CodeNotFound, code)
+	}
+	if code := r.invoke("grantOperator", "dave", "example.com", "bob", PermRecords); code != CodeUnauthorized {
+		t.Fatalf("Grant by an outsider: expected %s, got %q", CodeUnauthorized, code)
+	}
+	r.mustInvoke("grantOperator", "alice", "example.com", "bob", PermRecords)
+	r.mustInvoke("grantOperator", "alice", "example.com", "carol", PermRenew)
+
+	r.mustInvoke("updateDomain", "bob", "example.com", "10.0.0.2")
+	if code := r.invoke("renewDomain", "bob", "example.com", "30"); code != CodeUnauthorized {
+		t.Fatalf("Renewal with records: expected %s, got %q", CodeUnauthorized, code)
+	}
+	r.mustInvoke("renewDomain", "carol", "example.com", "30")
+	if code := r.invoke("updateDomain", "carol", "example.com", "10.0.0.3"); code != CodeUnauthorized {
+		t.Fatalf("Update with renew: expected %s, got %q", CodeUnauthorized, code)
+	}
+	domain := r.domain("example.com")
+	if domain.Owner != "alice" || domain.IPAddress != "10.0.0.2" || domain.DurationDays != "395" {
+		t.Fatalf("Unexpected domain: %+v", domain)
+	}
+
+	// only the owner can grant, revoke, transfer, delete or lock
+	owner := []struct {
+		function string
+		params   []string
+	}{
+		{"grantOperator", []string{"example.com", "dave", PermRecords}},
+		{"revokeOperator", []string{"example.com", "carol"}},
+		{"transferDomain", []string{"example.com", "dave", "10.0.0.4"}},
+		{"deleteDomain", []string{"example.com"}},
+		{"lockDomain", []string{"example.com", StatusTransferProhibited}},
+	}
+	for _, test := range owner {
+		if code := r.invoke(test.function, "bob", test.params...); code != CodeUnauthorized {
+			t.Fatalf("%s by an operator: expected %s, got %q", test.function, CodeUnauthorized, code)
+		}
+	}
+
+	// a new grant replaces the old one
+	r.mustInvoke("grantOperator", "alice", "example.com", "bob", PermRecords+","+PermRenew)
+	r.mustInvoke("renewDomain", "bob", "example.com", "30")
+	for _, operator := range r.operators("example.com") {
+		if operator.Account == "bob" && len(operator.Permissions) != 2 {
+			t.Fatalf("Unexpected grant %+v", operator)
+		}
+	}
+
+	r.mustInvoke("revokeOperator", "alice", "example.com", "bob")
+	if code := r.invoke("updateDomain", "bob", "example.com", "10.0.0.5"); code != CodeUnauthorized {
+		t.Fatalf("Update after revocation: expected %s, got %q", CodeUnauthorized, code)
+	}
+	if code := r.invoke("revokeOperator", "alice", "example.com", "bob"); code != CodeNotFound {
+		t.Fatalf("Revoking twice: expected %s, got %q", CodeNotFound, code)
+	}
+}
+
+func TestOperatorGrantExpiry(t *testing.T) {
+	r := newAuctionRegistry(t)
+	r.createAccount("carol")
+	r.mustInvoke("registerDomain", "alice", "example.com", "10.0.0.1", "365")
+	granted := r.now
+	r.mustInvoke("grantOperator", "alice", "example.com", "bob", PermRecords, "2")
+	expires := granted.AddDate(0, 0, 2)
+	if operators := r.operators("example.com"); len(operators) != 1 || operators[0].Expires != expires.Format(timeFormat) {
+		t.Fatalf("Unexpected operators %+v", operators)
+	}
+
+	r.now = expires.Add(-time.Second)
+	r.mustInvoke("updateDomain", "bob", "example.com", "10.0.0.2")
+	r.now = expires
+	if code := r.invoke("updateDomain", "bob", "example.com", "10.0.0.3"); code != CodeExpired {
+		t.Fatalf("Update after the grant expired: expected %s, got %q", CodeExpired, code)
+	}
+	// expired grants are still listed
+	if operators := r.operators("example.com"); len(operators) != 1 {
+		t.Fatalf("Unexpected operators %+v", operators)
+	}
+
+	r.mustInvoke("grantOperator", "alice", "example.com", "bob", PermRecords, "0")
+	r.now = r.now.AddDate(0, 0, 30)
+	r.mustInvoke("updateDomain", "bob", "example.com", "10.0.0.3")
+
+	// grants end with the ownership
+	r.mustInvoke("placeBid", "carol", "alice", "example.com", "10")
+	r.mustInvoke("transferDomain", "alice", "example.com", "carol", "10.0.0.4")
+	if operators := r.operators("example.com"); len(operators) != 0 {
+		t.Fatalf("Unexpected operators after the transfer %+v", operators)
+	}
+	if code := r.invoke("updateDomain", "bob", "example.com", "10.0.0.5"); code != CodeUnauthorized {
+		t.Fatalf("Update after the transfer: expected %s, got %q", CodeUnauthorized, code)
+	}
+}
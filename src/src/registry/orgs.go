@@ -0,0 +1,618 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// An organisation is an account without a key, owned by its members. It has
+// a RegisteredUsers row like any account, so it can own domains, bid and hold
+// escrow, but it cannot sign. Instead a member proposes an action on its
+// behalf, and the action runs once enough members have approved it: the
+// threshold set for the action, or the default threshold of the
+// organisation. The proposer's own approval counts. A proposal is identified
+// by the ID of the transaction that made it.
+//
+// Actions are the orgActions: the invokes an account can make on its own
+// domains and bids, and the organisation's membership changes. Their
+// arguments are those of the invoke without account and signature, passed as
+// a JSON array of strings.
+//
+// A proposal that reaches its threshold runs in the same transaction as the
+// last approval. If the action fails, the approval fails with it, and the
+// proposal has to be cancelled and made again once the cause is fixed.
+const (
+	organizationsTable = "Organizations"
+	orgProposalsTable  = "OrgProposals"
+)
+
+// Proposal statuses
+const (
+	proposalOpen      = "open"
+	proposalExecuted  = "executed"
+	proposalCancelled = "cancelled"
+)
+
+// columns of the Organizations table
+const (
+	orgNameColumn = iota
+	orgMembersColumn
+	orgDefaultThresholdColumn
+	orgThresholdsColumn
+	orgCreatedColumn
+)
+
+// columns of the OrgProposals table
+const (
+	proposalIDColumn = iota
+	proposalOrgColumn
+	proposalActionColumn
+	proposalArgsColumn
+	proposalProposerColumn
+	proposalApprovalsColumn
+	proposalStatusColumn
+	proposalCreatedColumn
+)
+
+// orgThresholdDefault names the default threshold in setOrgThreshold
+const orgThresholdDefault = "default"
+
+// orgActions lists what an organisation can do. The registry invokes take
+// the arguments of their schema after account and signature; the membership
+// changes are only reachable through proposals.
+var orgActions = map[string][]ArgSpec{
+	"registerDomain":  nil,
+	"transferDomain":  nil,
+	"placeBid":        nil,
+	"updateDomain":    nil,
+	"renewDomain":     nil,
+	"grantOperator":   nil,
+	"revokeOperator":  nil,
+	"deleteDomain":    nil,
+	"lockDomain":      nil,
+	"requestUnlock":   nil,
+	"confirmUnlock":   nil,
+	"cancelTransfer":  nil,
+	"withdraw":        nil,
+	"bidAuction":      nil,
+	"commitBid":       nil,
+	"revealBid":       nil,
+	"settleAuction":   nil,
+	"addOrgMember":    {{Name: "member", Type: ArgString}},
+	"removeOrgMember": {{Name: "member", Type: ArgString}},
+	"setOrgThreshold": {{Name: "action", Type: ArgString}, {Name: "threshold", Type: ArgUint}},
+}
+
+// Organization is the payload returned by getOrganization
+type Organization struct {
+	Name             string         `json:"name"`
+	Members          []string       `json:"members"`
+	DefaultThreshold int            `json:"defaultThreshold"`
+	Thresholds       map[string]int `json:"thresholds"`
+	Created          string         `json:"created"`
+}
+
+// Proposal is one entry of the payload returned by getOrgProposals
+type Proposal struct {
+	ID        string   `json:"id"`
+	Org       string   `json:"organization"`
+	Action    string   `json:"action"`
+	Args      []string `json:"args"`
+	Proposer  string   `json:"proposer"`
+	Approvals []string `json:"approvals"`
+	Status    string   `json:"status"`
+	Created   string   `json:"created"`
+}
+
+func createOrganizationTables(stub *shim.ChaincodeStub) error {
+	err := stub.CreateTable(organizationsTable, []*shim.ColumnDefinition{
+		{"orgName", shim.ColumnDefinition_STRING, true, false},
+		{"Members", shim.ColumnDefinition_STRING, false, false},
+		{"DefaultThreshold", shim.ColumnDefinition_STRING, false, false},
+		{"Thresholds", shim.ColumnDefinition_STRING, false, false},
+		{"Created", shim.ColumnDefinition_STRING, false, false},
+	})
+	if err != nil {
+		return err
+	}
+	return stub.CreateTable(orgProposalsTable, []*shim.ColumnDefinition{
+		{"ProposalID", shim.ColumnDefinition_STRING, true, false},
+		{"orgName", shim.ColumnDefinition_STRING, false, false},
+		{"Action", shim.ColumnDefinition_STRING, false, false},
+		{"Args", shim.ColumnDefinition_STRING, false, false},
+		{"Proposer", shim.ColumnDefinition_STRING, false, false},
+		{"Approvals", shim.ColumnDefinition_STRING, false, false},
+		{"Status", shim.ColumnDefinition_STRING, false, false},
+		{"Created", shim.ColumnDefinition_STRING, false, false},
+	})
+}
+
+func getOrganizationRow(stub TableReader, orgName string) (shim.Row, error) {
+	row, err := stub.GetRow(organizationsTable, stringKey(orgName))
+	if err != nil {
+		return row, newError(CodeInternal, "Error reading organizations: %s", err)
+	}
+	if len(row.Columns) == 0 {
+		return row, newError(CodeNotFound, "Organization %s does not exist", orgName)
+	}
+	return row, nil
+}
+
+func organizationOf(row shim.Row) (*Organization, error) {
+	org := &Organization{
+		Name:       row.Columns[orgNameColumn].GetString_(),
+		Members:    splitList(row.Columns[orgMembersColumn].GetString_()),
+		Thresholds: make(map[string]int),
+		Created:    row.Columns[orgCreatedColumn].GetString_(),
+	}
+	var err error
+	org.DefaultThreshold, err = strconv.Atoi(row.Columns[orgDefaultThresholdColumn].GetString_())
+	if err != nil {
+		return nil, newError(CodeInternal, "Invalid threshold of %s", org.Name)
+	}
+	for _, entry := range splitList(row.Columns[orgThresholdsColumn].GetString_()) {
+		parts := strings.SplitN(entry, "=", 2)
+		threshold, err := strconv.Atoi(parts[len(parts)-1])
+		if len(parts) != 2 || err != nil {
+			return nil, newError(CodeInternal, "Invalid threshold %q of %s", entry, org.Name)
+		}
+		org.Thresholds[parts[0]] = threshold
+	}
+	return org, nil
+}
+
+func (o *Organization) isMember(userEmail string) bool {
+	for _, member := range o.Members {
+		if member == userEmail {
+			return true
+		}
+	}
+	return false
+}
+
+// threshold returns the number of approvals action needs
+func (o *Organization) threshold(action string) int {
+	if threshold, ok := o.Thresholds[action]; ok {
+		return threshold
+	}
+	return o.DefaultThreshold
+}
+
+func putOrganization(stub *shim.ChaincodeStub, org *Organization, replace bool) error {
+	var thresholds []string
+	for action := range orgActions {
+		if threshold, ok := org.Thresholds[action]; ok {
+			thresholds = append(thresholds, action+"="+strconv.Itoa(threshold))
+		}
+	}
+	// orgActions is a map; keep the column the same on every peer
+	sort.Strings(thresholds)
+	row := shim.Row{
+		Columns: []*shim.Column{
+			{Value: &shim.Column_String_{String_: org.Name}},
+			{Value: &shim.Column_String_{String_: strings.Join(org.Members, ",")}},
+			{Value: &shim.Column_String_{String_: strconv.Itoa(org.DefaultThreshold)}},
+			{Value: &shim.Column_String_{String_: strings.Join(thresholds, ",")}},
+			{Value: &shim.Column_String_{String_: org.Created}},
+		},
+	}
+	var err error
+	if replace {
+		_, err = stub.ReplaceRow(organizationsTable, row)
+	} else {
+		_, err = stub.InsertRow(organizationsTable, row)
+	}
+	if err != nil {
+		return newError(CodeInternal, "Error writing organization %s: %s", org.Name, err)
+	}
+	return nil
+}
+
+// checkMemberAccount fails unless userEmail is an account that can sign
+func checkMemberAccount(stub *shim.ChaincodeStub, userEmail string) error {
+	row, err := getAccountRow(stub, userEmail)
+	if err != nil {
+		return err
+	}
+	if row.Columns[accountPubKeyColumn].GetString_() == "" {
+		return newError(CodeInvalidArgument, "%s is an organization and cannot be a member", userEmail)
+	}
+	return nil
+}
+
+// organizationsOf returns the organisations userEmail is a member of
+func organizationsOf(stub TableReader, userEmail string) ([]string, error) {
+	rows, err := readAllRows(stub, organizationsTable)
+	if err != nil {
+		return nil, err
+	}
+	var orgs []string
+	for _, row := range rows {
+		for _, member := range splitList(row.Columns[orgMembersColumn].GetString_()) {
+			if member == userEmail {
+				orgs = append(orgs, row.Columns[orgNameColumn].GetString_())
+			}
+		}
+	}
+	return orgs, nil
+}
+
+// createOrganization creates an organisation account. The caller becomes a
+// member alongside the listed ones.
+//
+// args: account, signature, orgName, members, defaultThreshold
+func (t *DNSChaincode) createOrganization(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	orgName := args[2]
+	members := []string{args[0]}
+	for _, member := range splitList(args[3]) {
+		if member == args[0] {
+			continue
+		}
+		err := checkMemberAccount(stub, member)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	threshold, _ := strconv.Atoi(args[4])
+	if threshold < 1 || threshold > len(members) {
+		return nil, newError(CodeInvalidArgument, "Threshold must be between 1 and %d", len(members))
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	inserted, err := stub.InsertRow("RegisteredUsers", shim.Row{
+		Columns: []*shim.Column{
+			{Value: &shim.Column_String_{String_: orgName}},
+			{Value: &shim.Column_String_{String_: ""}},
+			{Value: &shim.Column_String_{String_: ""}},
+			dateColumn(now),
+			{Value: &shim.Column_String_{String_: ""}},
+			{Value: &shim.Column_String_{String_: ""}},
+			{Value: &shim.Column_String_{String_: ""}},
+		},
+	})
+	if err != nil {
+		return nil, newError(CodeInternal, "Error creating row: %s", err)
+	}
+	if !inserted {
+		return nil, newError(CodeConflict, "Account %s already exists", orgName)
+	}
+	err = addStat(stub, statsKindTotal, statsAccounts, 1)
+	if err != nil {
+		return nil, err
+	}
+	return nil, putOrganization(stub, &Organization{
+		Name:             orgName,
+		Members:          members,
+		DefaultThreshold: threshold,
+		Thresholds:       make(map[string]int),
+		Created:          now.Format(timeFormat),
+	}, false)
+}
+
+// proposeOrgAction proposes an action on behalf of an organisation
+//
+// args: account, signature, orgName, action, actionArgs
+func (t *DNSChaincode) proposeOrgAction(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	orgName := args[2]
+	action := args[3]
+	row, err := getOrganizationRow(stub, orgName)
+	if err != nil {
+		return nil, err
+	}
+	org, err := organizationOf(row)
+	if err != nil {
+		return nil, err
+	}
+	if !org.isMember(args[0]) {
+		return nil, newError(CodeUnauthorized, "%s is not a member of %s", args[0], orgName)
+	}
+	var actionArgs []string
+	err = json.Unmarshal([]byte(args[4]), &actionArgs)
+	if err != nil {
+		return nil, newError(CodeInvalidArgument, "Action arguments must be a JSON array of strings: %s", err)
+	}
+	err = validateOrgAction(action, actionArgs)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	proposalID := stub.UUID
+	_, err = stub.InsertRow(orgProposalsTable, shim.Row{
+		Columns: []*shim.Column{
+			{Value: &shim.Column_String_{String_: proposalID}},
+			{Value: &shim.Column_String_{String_: orgName}},
+			{Value: &shim.Column_String_{String_: action}},
+			{Value: &shim.Column_String_{String_: args[4]}},
+			{Value: &shim.Column_String_{String_: args[0]}},
+			{Value: &shim.Column_String_{String_: args[0]}},
+			{Value: &shim.Column_String_{String_: proposalOpen}},
+			{Value: &shim.Column_String_{String_: now.Format(timeFormat)}},
+		},
+	})
+	if err != nil {
+		return nil, newError(CodeInternal, "Error creating row: %s", err)
+	}
+	if org.threshold(action) <= 1 {
+		err = t.executeProposal(stub, org, proposalID, action, actionArgs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return []byte(proposalID), nil
+}
+
+// validateOrgAction checks that action is an orgAction and its arguments
+// match the schema
+func validateOrgAction(action string, actionArgs []string) error {
+	specs, ok := orgActions[action]
+	if !ok {
+		return newError(CodeInvalidArgument, "Organizations cannot %s", action)
+	}
+	if specs == nil {
+		schema, err := lookupSchema(KindInvoke, action)
+		if err != nil {
+			return err
+		}
+		specs = schema.Args[2:]
+	}
+	schema := &FunctionSchema{Name: action, Kind: KindInvoke, Args: specs}
+	return schema.validate(actionArgs)
+}
+
+func getOpenProposal(stub *shim.ChaincodeStub, proposalID string) (shim.Row, error) {
+	row, err := stub.GetRow(orgProposalsTable, stringKey(proposalID))
+	if err != nil {
+		return row, newError(CodeInternal, "Error reading proposals: %s", err)
+	}
+	if len(row.Columns) == 0 {
+		return row, newError(CodeNotFound, "Proposal %s does not exist", proposalID)
+	}
+	if row.Columns[proposalStatusColumn].GetString_() != proposalOpen {
+		return row, newError(CodeConflict, "Proposal %s is %s", proposalID, row.Columns[proposalStatusColumn].GetString_())
+	}
+	return row, nil
+}
+
+func replaceProposalColumns(stub *shim.ChaincodeStub, row shim.Row, values map[int]string) error {
+	columns := make([]*shim.Column, len(row.Columns))
+	copy(columns, row.Columns)
+	for i, value := range values {
+		columns[i] = &shim.Column{Value: &shim.Column_String_{String_: value}}
+	}
+	_, err := stub.ReplaceRow(orgProposalsTable, shim.Row{Columns: columns})
+	if err != nil {
+		return newError(CodeInternal, "Error updating proposal: %s", err)
+	}
+	return nil
+}
+
+// approveOrgProposal adds the caller's approval to a proposal, and runs it if
+// that makes enough approvals
+//
+// args: account, signature, proposalID
+func (t *DNSChaincode) approveOrgProposal(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	proposalID := args[2]
+	row, err := getOpenProposal(stub, proposalID)
+	if err != nil {
+		return nil, err
+	}
+	orgRow, err := getOrganizationRow(stub, row.Columns[proposalOrgColumn].GetString_())
+	if err != nil {
+		return nil, err
+	}
+	org, err := organizationOf(orgRow)
+	if err != nil {
+		return nil, err
+	}
+	if !org.isMember(args[0]) {
+		return nil, newError(CodeUnauthorized, "%s is not a member of %s", args[0], org.Name)
+	}
+	approvals := splitList(row.Columns[proposalApprovalsColumn].GetString_())
+	for _, approver := range approvals {
+		if approver == args[0] {
+			return nil, newError(CodeConflict, "%s already approved proposal %s", args[0], proposalID)
+		}
+	}
+	approvals = append(approvals, args[0])
+	err = replaceProposalColumns(stub, row, map[int]string{proposalApprovalsColumn: strings.Join(approvals, ",")})
+	if err != nil {
+		return nil, err
+	}
+
+	// Approvals of members who have left since do not count
+	count := 0
+	for _, approver := range approvals {
+		if org.isMember(approver) {
+			count++
+		}
+	}
+	action := row.Columns[proposalActionColumn].GetString_()
+	if count < org.threshold(action) {
+		return nil, nil
+	}
+	var actionArgs []string
+	err = json.Unmarshal([]byte(row.Columns[proposalArgsColumn].GetString_()), &actionArgs)
+	if err != nil {
+		return nil, newError(CodeInternal, "Invalid arguments of proposal %s", proposalID)
+	}
+	return nil, t.executeProposal(stub, org, proposalID, action, actionArgs)
+}
+
+// executeProposal runs an approved proposal as the organisation and marks it
+// executed
+func (t *DNSChaincode) executeProposal(stub *shim.ChaincodeStub, org *Organization, proposalID string, action string, actionArgs []string) error {
+	row, err := getOpenProposal(stub, proposalID)
+	if err != nil {
+		return err
+	}
+	err = replaceProposalColumns(stub, row, map[int]string{proposalStatusColumn: proposalExecuted})
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "addOrgMember":
+		return addOrgMember(stub, org, actionArgs[0])
+	case "removeOrgMember":
+		return removeOrgMember(stub, org, actionArgs[0])
+	case "setOrgThreshold":
+		threshold, _ := strconv.Atoi(actionArgs[1])
+		return setOrgThreshold(stub, org, actionArgs[0], threshold)
+	}
+	_, err = t.dispatch(stub, action, append([]string{org.Name, ""}, actionArgs...))
+	return err
+}
+
+func addOrgMember(stub *shim.ChaincodeStub, org *Organization, member string) error {
+	if org.isMember(member) {
+		return newError(CodeConflict, "%s is already a member of %s", member, org.Name)
+	}
+	err := checkMemberAccount(stub, member)
+	if err != nil {
+		return err
+	}
+	org.Members = append(org.Members, member)
+	return putOrganization(stub, org, true)
+}
+
+// removeOrgMember drops a member, as long as every threshold can still be
+// met by the members left
+func removeOrgMember(stub *shim.ChaincodeStub, org *Organization, member string) error {
+	if !org.isMember(member) {
+		return newError(CodeNotFound, "%s is not a member of %s", member, org.Name)
+	}
+	var members []string
+	for _, existing := range org.Members {
+		if existing != member {
+			members = append(members, existing)
+		}
+	}
+	if org.DefaultThreshold > len(members) {
+		return newError(CodeConflict, "%s would be left with %d members, fewer than its threshold of %d", org.Name, len(members), org.DefaultThreshold)
+	}
+	for action, threshold := range org.Thresholds {
+		if threshold > len(members) {
+			return newError(CodeConflict, "%s would be left with %d members, fewer than the threshold of %s", org.Name, len(members), action)
+		}
+	}
+	org.Members = members
+	return putOrganization(stub, org, true)
+}
+
+// setOrgThreshold sets the approvals action needs, or the default threshold
+// when action is orgThresholdDefault
+func setOrgThreshold(stub *shim.ChaincodeStub, org *Organization, action string, threshold int) error {
+	if _, ok := orgActions[action]; !ok && action != orgThresholdDefault {
+		return newError(CodeInvalidArgument, "Organizations cannot %s", action)
+	}
+	if threshold < 1 || threshold > len(org.Members) {
+		return newError(CodeInvalidArgument, "Threshold must be between 1 and %d", len(org.Members))
+	}
+	if action == orgThresholdDefault {
+		org.DefaultThreshold = threshold
+	} else {
+		org.Thresholds[action] = threshold
+	}
+	return putOrganization(stub, org, true)
+}
+
+// cancelOrgProposal withdraws an open proposal. Only its proposer can.
+//
+// args: account, signature, proposalID
+func (t *DNSChaincode) cancelOrgProposal(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	row, err := getOpenProposal(stub, args[2])
+	if err != nil {
+		return nil, err
+	}
+	if row.Columns[proposalProposerColumn].GetString_() != args[0] {
+		return nil, newError(CodeUnauthorized, "Only %s can cancel proposal %s", row.Columns[proposalProposerColumn].GetString_(), args[2])
+	}
+	return nil, replaceProposalColumns(stub, row, map[int]string{proposalStatusColumn: proposalCancelled})
+}
+
+// getOrganization returns the members and thresholds of an organisation
+//
+// args: orgName
+func (t *DNSChaincode) getOrganization(stub *shim.ChaincodeStub, args []string) (*Organization, error) {
+	row, err := getOrganizationRow(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	return organizationOf(row)
+}
+
+// getOrgProposals returns the proposals of an organisation, only the open
+// ones unless all is "true"
+//
+// args: orgName, all
+func (t *DNSChaincode) getOrgProposals(stub *shim.ChaincodeStub, args []string) ([]*Proposal, error) {
+	_, err := getOrganizationRow(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	all := len(args) > 1 && args[1] == "true"
+	rows, err := readAllRows(stub, orgProposalsTable)
+	if err != nil {
+		return nil, err
+	}
+	proposals := []*Proposal{}
+	for _, row := range rows {
+		status := row.Columns[proposalStatusColumn].GetString_()
+		if row.Columns[proposalOrgColumn].GetString_() != args[0] || (!all && status != proposalOpen) {
+			continue
+		}
+		proposal := &Proposal{
+			ID:        row.Columns[proposalIDColumn].GetString_(),
+			Org:       args[0],
+			Action:    row.Columns[proposalActionColumn].GetString_(),
+			Proposer:  row.Columns[proposalProposerColumn].GetString_(),
+			Approvals: splitList(row.Columns[proposalApprovalsColumn].GetString_()),
+			Status:    status,
+			Created:   row.Columns[proposalCreatedColumn].GetString_(),
+		}
+		json.Unmarshal([]byte(row.Columns[proposalArgsColumn].GetString_()), &proposal.Args)
+		proposals = append(proposals, proposal)
+	}
+	return proposals, nil
+}
+
+// checkNotOrganizationMember is called by closeAccount: a member has to
+// leave its organisations first, or their thresholds could become unreachable
+func checkNotOrganizationMember(stub *shim.ChaincodeStub, userEmail string) error {
+	orgs, err := organizationsOf(stub, userEmail)
+	if err != nil {
+		return err
+	}
+	if len(orgs) != 0 {
+		return newError(CodeConflict, "Account is a member of %s. Leave before closing the account.", strings.Join(orgs, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// newOrgRegistry deploys the chaincode with the organisation acme of alice,
+// bob and carol, which needs two approvals
+func newOrgRegistry(t *testing.T) *testRegistry {
+	r := newAuctionRegistry(t)
+	r.createAccount("carol")
+	r.mustInvoke("createOrganization", "alice", "acme", "bob,carol", "2")
+	return r
+}
+
+// propose proposes an action of orgName as userEmail and returns the ID of
+// the proposal
+func (r *testRegistry) propose(userEmail string, orgName string, action string, actionArgs ...string) string {
+	encoded, _ := json.Marshal(actionArgs)
+	payload, err := r.peer.Invoke("proposeOrgAction", r.signedArgs("proposeOrgAction", userEmail, orgName, action, string(encoded)), r.now)
+	if err != nil {
+		r.t.Fatalf("proposeOrgAction of %s by %s failed: %s", action, userEmail, err)
+	}
+	return string(payload)
+}
+
+func (r *testRegistry) proposals(orgName string, all bool) []Proposal {
+	var proposals []Proposal
+	args := []string{orgName}
+	if all {
+		args = append(args, "true")
+	}
+	r.query("getOrgProposals", args, &proposals)
+	return proposals
+}
+
+func (r *testRegistry) organization(orgName string) Organization {
+	var org Organization
+	r.query("getOrganization", []string{orgName}, &org)
+	return org
+}
+
+func TestCreateOrganization(t *testing.T) {
+	r := newAuctionRegistry(t)
+	if code := r.invoke("createOrganization", "alice", "acme", "bob", "3"); code != CodeInvalidArgument {
+		t.Fatalf("Threshold above the members: expected %s, got %q", CodeInvalidArgument, code)
+	}
+	if code := r.invoke("createOrganization", "alice", "acme", "carol", "1"); code != CodeNotFound {
+		t.Fatalf("Missing member: expected %s, got %q", CodeNotFound, code)
+	}
+	if code := r.invoke("createOrganization", "alice", "bob", "", "1"); code != CodeConflict {
+		t.Fatalf("Organisation named after an account: expected %s, got %q", CodeConflict, code)
+	}
+	r.mustInvoke("createOrganization", "alice", "acme", "bob,alice", "2")
+	org := r.organization("acme")
+	if len(org.Members) != 2 || org.Members[0] != "alice" || org.Members[1] != "bob" || org.DefaultThreshold != 2 {
+		t.Fatalf("Unexpected organisation: %+v", org)
+	}
+}
+
+func TestOrgProposals(t *testing.T) {
+	r := newOrgRegistry(t)
+	invalid := []struct {
+		name       string
+		action     string
+		actionArgs string
+	}{
+		{"Arguments that are not JSON", "registerDomain", "acme.com"},
+		{"Action an organisation cannot take", "closeAccount", "[]"},
+		{"Arguments that do not match the action", "registerDomain", `["acme.com", "not an address", "365"]`},
+	}
+	for _, test := range invalid {
+		if code := r.invoke("proposeOrgAction", "alice", "acme", test.action, test.actionArgs); code != CodeInvalidArgument {
+			t.Fatalf("%s: expected %s, got %q", test.name, CodeInvalidArgument, code)
+		}
+	}
+	r.createAccount("dave")
+	if code := r.invoke("proposeOrgAction", "dave", "acme", "registerDomain", `["acme.com", "10.0.0.1", "365"]`); code != CodeUnauthorized {
+		t.Fatalf("Proposed by an outsider: expected %s, got %q", CodeUnauthorized, code)
+	}
+
+	register := r.propose("alice", "acme", "registerDomain", "acme.com", "10.0.0.1", "365")
+	proposals := r.proposals("acme", false)
+	if len(proposals) != 1 || proposals[0].ID != register || proposals[0].Action != "registerDomain" ||
+		len(proposals[0].Args) != 3 || len(proposals[0].Approvals) != 1 || proposals[0].Status != proposalOpen {
+		t.Fatalf("Unexpected proposals: %+v", proposals)
+	}
+	if check := r.checkName("acme.com"); check.Registered {
+		t.Fatalf("Proposal ran before it was approved: %+v", check)
+	}
+	if code := r.invoke("approveOrgProposal", "alice", register); code != CodeConflict {
+		t.Fatalf("Approved twice: expected %s, got %q", CodeConflict, code)
+	}
+	if code := r.invoke("approveOrgProposal", "dave", register); code != CodeUnauthorized {
+		t.Fatalf("Approved by an outsider: expected %s, got %q", CodeUnauthorized, code)
+	}
+	r.mustInvoke("approveOrgProposal", "bob", register)
+	if domain := r.domain("acme.com"); domain.Owner != "acme" {
+		t.Fatalf("Unexpected domain of the organisation: %+v", domain)
+	}
+	if code := r.invoke("approveOrgProposal", "carol", register); code != CodeConflict {
+		t.Fatalf("Approving an executed proposal: expected %s, got %q", CodeConflict, code)
+	}
+
+	remove := r.propose("alice", "acme", "deleteDomain", "acme.com")
+	if code := r.invoke("cancelOrgProposal", "bob", remove); code != CodeUnauthorized {
+		t.Fatalf("Cancelled by another member: expected %s, got %q", CodeUnauthorized, code)
+	}
+	r.mustInvoke("cancelOrgProposal", "alice", remove)
+	if code := r.invoke("approveOrgProposal", "bob", remove); code != CodeConflict {
+		t.Fatalf("Approving a cancelled proposal: expected %s, got %q", CodeConflict, code)
+	}
+	if code := r.invoke("approveOrgProposal", "bob", "missing"); code != CodeNotFound {
+		t.Fatalf("Approving a missing proposal: expected %s, got %q", CodeNotFound, code)
+	}
+	if domain := r.domain("acme.com"); domain.Owner != "acme" {
+		t.Fatalf("Cancelled proposal ran: %+v", domain)
+	}
+
+	if proposals = r.proposals("acme", false); len(proposals) != 0 {
+		t.Fatalf("Expected no open proposals, got %+v", proposals)
+	}
+	statuses := make(map[string]string)
+	for _, proposal := range r.proposals("acme", true) {
+		statuses[proposal.ID] = proposal.Status
+	}
+	if len(statuses) != 2 || statuses[register] != proposalExecuted || statuses[remove] != proposalCancelled {
+		t.Fatalf("Unexpected proposal statuses: %+v", statuses)
+	}
+}
+
+func TestOrgMembersAndThresholds(t *testing.T) {
+	r := newOrgRegistry(t)
+	r.mustInvoke("approveOrgProposal", "bob", r.propose("alice", "acme", "registerDomain", "acme.com", "10.0.0.1", "365"))
+
+	// a threshold of one runs the proposal straight away
+	r.mustInvoke("approveOrgProposal", "bob", r.propose("alice", "acme", "setOrgThreshold", "updateDomain", "1"))
+	r.propose("carol", "acme", "updateDomain", "acme.com", "10.0.0.2")
+	if domain := r.domain("acme.com"); domain.IPAddress != "10.0.0.2" {
+		t.Fatalf("Proposal with a threshold of one did not run: %+v", domain)
+	}
+
+	// approvals of members who have left do not count
+	renew := r.propose("carol", "acme", "renewDomain", "acme.com", "30")
+	r.mustInvoke("approveOrgProposal", "bob", r.propose("alice", "acme", "removeOrgMember", "carol"))
+	if org := r.organization("acme"); org.isMember("carol") {
+		t.Fatalf("Member was not removed: %+v", org)
+	}
+	r.mustInvoke("approveOrgProposal", "alice", renew)
+	if domain := r.domain("acme.com"); domain.DurationDays != "365" {
+		t.Fatalf("Proposal ran on the approval of a former member: %+v", domain)
+	}
+
+	// a failing action fails the approval and leaves the proposal open
+	leave := r.propose("alice", "acme", "removeOrgMember", "bob")
+	if code := r.invoke("approveOrgProposal", "bob", leave); code != CodeConflict {
+		t.Fatalf("Removing a member below the threshold: expected %s, got %q", CodeConflict, code)
+	}
+	open := make(map[string][]string)
+	for _, proposal := range r.proposals("acme", false) {
+		open[proposal.ID] = proposal.Approvals
+	}
+	if approvals, ok := open[leave]; !ok || len(approvals) != 1 {
+		t.Fatalf("Failed approval was kept: %+v", open)
+	}
+	if code := r.invoke("closeAccount", "bob"); code != CodeConflict {
+		t.Fatalf("Closing a member: expected %s, got %q", CodeConflict, code)
+	}
+}
@@ -0,0 +1,482 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Administrators keep a list of name rules in the ReservedNames table. A rule
+// matches names exactly, by glob (path.Match syntax), by regular expression
+// (matched against the whole name) or by confusable skeleton, and either
+// blocks the names it matches or reserves them. Blocked names can never be
+// registered. A reserved name can only be registered by the account an
+// administrator allocated it to, with a signed allocation token, or by the
+// winner of an auction of it.
+//
+// Independently of the rules, a name whose skeleton is the same as that of a
+// registered domain, like "examp1e" next to "example", cannot be registered.
+// The DomainSkeletons table indexes the skeletons of registered domains.
+//
+// Names are matched in lower case.
+const (
+	reservedNamesTable   = "ReservedNames"
+	domainSkeletonsTable = "DomainSkeletons"
+)
+
+// Reservation rule kinds
+const (
+	RuleExact      = "exact"
+	RuleGlob       = "glob"
+	RuleRegex      = "regex"
+	RuleConfusable = "confusable"
+)
+
+// Reservation actions
+const (
+	ActionReserve = "reserve"
+	ActionBlock   = "block"
+)
+
+// columns of the ReservedNames table
+const (
+	ruleKindColumn = iota
+	rulePatternColumn
+	ruleActionColumn
+	ruleReasonColumn
+	ruleAddedByColumn
+)
+
+// Reservation is one rule, as returned by getReservations and checkName
+type Reservation struct {
+	Kind    string `json:"kind"`
+	Pattern string `json:"pattern"`
+	Action  string `json:"action"`
+	Reason  string `json:"reason,omitempty"`
+	AddedBy string `json:"addedBy,omitempty"`
+}
+
+// NameCheck is the payload returned by checkName
+type NameCheck struct {
+	Domain         string       `json:"domainName"`
+	Available      bool         `json:"available"`
+	Registered     bool         `json:"registered,omitempty"`
+	ConfusableWith string       `json:"confusableWith,omitempty"`
+	Rule           *Reservation `json:"rule,omitempty"`
+}
+
+// confusables maps characters to the ASCII letter they are mistaken for.
+// It covers the look-alikes of the Latin letters in Cyrillic, Greek and a
+// few other scripts, and the digits that pass for letters; see Unicode
+// TR39 for the full list.
+var confusables = map[rune]string{
+	'0': "o", '1': "l", '|': "l", 'ı': "i", 'ł': "l", 'ſ': "f",
+	// Cyrillic
+	'а': "a", 'в': "b", 'е': "e", 'ё': "e", 'һ': "h", 'і': "i", 'ї': "i",
+	'ј': "j", 'к': "k", 'м': "m", 'н': "h", 'о': "o", 'р': "p", 'с': "c",
+	'т': "t", 'у': "y", 'х': "x", 'ѕ': "s", 'ԁ': "d", 'ԛ': "q", 'ԝ': "w",
+	'ɡ': "g", 'ӏ': "l",
+	// Greek
+	'α': "a", 'β': "b", 'ε': "e", 'η': "n", 'ι': "i", 'κ': "k", 'ν': "v",
+	'ο': "o", 'ρ': "p", 'τ': "t", 'υ': "u", 'χ': "x", 'ω': "w",
+}
+
+// confusableSequences are letter pairs that read as one letter
+var confusableSequences = strings.NewReplacer("rn", "m", "vv", "w", "cl", "d")
+
+// skeleton reduces a name to the form it shares with its look-alikes
+func skeleton(name string) string {
+	var mapped []string
+	for _, r := range strings.ToLower(name) {
+		if replacement, ok := confusables[r]; ok {
+			mapped = append(mapped, replacement)
+		} else {
+			mapped = append(mapped, string(r))
+		}
+	}
+	return confusableSequences.Replace(strings.Join(mapped, ""))
+}
+
+func createReservationTables(stub *shim.ChaincodeStub) error {
+	err := stub.CreateTable(reservedNamesTable, []*shim.ColumnDefinition{
+		{"Kind", shim.ColumnDefinition_STRING, true, false},
+		{"Pattern", shim.ColumnDefinition_STRING, true, false},
+		{"Action", shim.ColumnDefinition_STRING, false, false},
+		{"Reason", shim.ColumnDefinition_STRING, false, false},
+		{"AddedBy", shim.ColumnDefinition_STRING, false, false},
+	})
+	if err != nil {
+		return err
+	}
+	return createDomainSkeletonsTable(stub)
+}
+
+func createDomainSkeletonsTable(stub *shim.ChaincodeStub) error {
+	return stub.CreateTable(domainSkeletonsTable, []*shim.ColumnDefinition{
+		{"Skeleton", shim.ColumnDefinition_STRING, true, false},
+		{"domainName", shim.ColumnDefinition_STRING, false, false},
+	})
+}
+
+func isRuleKind(kind string) bool {
+	return kind == RuleExact || kind == RuleGlob || kind == RuleRegex || kind == RuleConfusable
+}
+
+func isRuleAction(action string) bool {
+	return action == ActionReserve || action == ActionBlock
+}
+
+func ruleKey(kind string, pattern string) []shim.Column {
+	return []shim.Column{
+		{Value: &shim.Column_String_{String_: kind}},
+		{Value: &shim.Column_String_{String_: pattern}},
+	}
+}
+
+func reservationOf(row shim.Row) *Reservation {
+	return &Reservation{
+		Kind:    row.Columns[ruleKindColumn].GetString_(),
+		Pattern: row.Columns[rulePatternColumn].GetString_(),
+		Action:  row.Columns[ruleActionColumn].GetString_(),
+		Reason:  row.Columns[ruleReasonColumn].GetString_(),
+		AddedBy: row.Columns[ruleAddedByColumn].GetString_(),
+	}
+}
+
+// matches reports whether the rule matches a lower case name
+func (r *Reservation) matches(name string) bool {
+	switch r.Kind {
+	case RuleExact:
+		return name == r.Pattern
+	case RuleGlob:
+		matched, err := path.Match(r.Pattern, name)
+		return err == nil && matched
+	case RuleRegex:
+		expression, err := regexp.Compile("^(?:" + r.Pattern + ")$")
+		return err == nil && expression.MatchString(name)
+	case RuleConfusable:
+		return skeleton(name) == skeleton(r.Pattern)
+	}
+	return false
+}
+
+// matchReservation returns the rule matching domainName, nil if none does.
+// A blocking rule wins over a reserving one.
+func matchReservation(stub TableReader, domainName string) (*Reservation, error) {
+	rows, err := readAllRows(stub, reservedNamesTable)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.ToLower(domainName)
+	var match *Reservation
+	for _, row := range rows {
+		rule := reservationOf(row)
+		if !rule.matches(name) {
+			continue
+		}
+		if rule.Action == ActionBlock {
+			return rule, nil
+		}
+		if match == nil {
+			match = rule
+		}
+	}
+	return match, nil
+}
+
+// confusableDomain returns the registered domain domainName can be mistaken
+// for, empty if there is none
+func confusableDomain(stub TableReader, domainName string) (string, error) {
+	row, err := stub.GetRow(domainSkeletonsTable, stringKey(skeleton(domainName)))
+	if err != nil {
+		return "", newError(CodeInternal, "Error reading domain skeletons: %s", err)
+	}
+	if len(row.Columns) == 0 || row.Columns[1].GetString_() == domainName {
+		return "", nil
+	}
+	return row.Columns[1].GetString_(), nil
+}
+
+// indexSkeleton records the skeleton of a newly registered domain. The first
+// domain with a skeleton keeps it.
+func indexSkeleton(stub *shim.ChaincodeStub, domainName string) error {
+	_, err := stub.InsertRow(domainSkeletonsTable, shim.Row{
+		Columns: []*shim.Column{
+			{Value: &shim.Column_String_{String_: skeleton(domainName)}},
+			{Value: &shim.Column_String_{String_: domainName}},
+		},
+	})
+	if err != nil {
+		return newError(CodeInternal, "Error indexing domain skeleton: %s", err)
+	}
+	return nil
+}
+
+// unindexSkeleton drops the skeleton of a released domain
+func unindexSkeleton(stub *shim.ChaincodeStub, domainName string) error {
+	key := stringKey(skeleton(domainName))
+	row, err := stub.GetRow(domainSkeletonsTable, key)
+	if err != nil {
+		return newError(CodeInternal, "Error reading domain skeletons: %s", err)
+	}
+	if len(row.Columns) == 0 || row.Columns[1].GetString_() != domainName {
+		return nil
+	}
+	err = stub.DeleteRow(domainSkeletonsTable, key)
+	if err != nil {
+		return newError(CodeInternal, "Error deleting row: %s", err)
+	}
+	return nil
+}
+
+func allocationMessage(domainName string, userEmail string, expires string) []byte {
+	return []byte(strings.Join([]string{"allocate", domainName, userEmail, expires}, ":"))
+}
+
+// checkAllocationToken verifies a token allocating domainName to userEmail.
+// A token is "administrator,expires,signature": expires is a Unix time and
+// signature is the administrator's hex encoded signature over
+// allocationMessage(domainName, userEmail, expires). The administrator must
+// still be one when the token is used.
+func (t *DNSChaincode) checkAllocationToken(stub *shim.ChaincodeStub, domainName string, userEmail string, token string) error {
+	parts := strings.Split(token, ",")
+	if len(parts) != 3 {
+		return newError(CodeInvalidArgument, "Allocation token must be administrator,expires,signature")
+	}
+	administrator, expires, signature := parts[0], parts[1], parts[2]
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return newError(CodeInvalidArgument, "Invalid allocation token expiry %q", expires)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	if now.Unix() >= expiresAt {
+		return newError(CodeExpired, "Allocation token of %s has expired", domainName)
+	}
+	err = requireAdministrator(stub, administrator)
+	if err != nil {
+		return err
+	}
+	pubKey := t.getUserPubKey(stub, []string{administrator})
+	if pubKey == "" {
+		return newError(CodeNotFound, "Account %s does not exist", administrator)
+	}
+	algorithm, err := getKeyAlgorithm(stub, administrator)
+	if err != nil {
+		return err
+	}
+	valid, err := t.verifySignature(pubKey, algorithm, allocationMessage(domainName, userEmail, expires), signature)
+	if err != nil || !valid {
+		return newError(CodeUnauthorized, "Allocation token of %s is not signed by %s", domainName, administrator)
+	}
+	return nil
+}
+
+// checkRegistrable is called by registerDomain. It refuses blocked names,
+// look-alikes of registered domains, and reserved names unless token
+// allocates them to userEmail or userEmail won them at auction.
+func (t *DNSChaincode) checkRegistrable(stub *shim.ChaincodeStub, domainName string, userEmail string, token string, wonAtAuction bool) error {
+	rule, err := matchReservation(stub, domainName)
+	if err != nil {
+		return err
+	}
+	if rule != nil && rule.Action == ActionBlock {
+		return newError(CodeConflict, "%s is blocked by %s rule %q", domainName, rule.Kind, rule.Pattern)
+	}
+	similar, err := confusableDomain(stub, domainName)
+	if err != nil {
+		return err
+	}
+	if similar != "" {
+		return newError(CodeConflict, "%s can be mistaken for the registered domain %s", domainName, similar)
+	}
+	if rule == nil || wonAtAuction {
+		return nil
+	}
+	if token == "" {
+		return newError(CodeConflict, "%s is reserved by %s rule %q and needs an allocation token", domainName, rule.Kind, rule.Pattern)
+	}
+	return t.checkAllocationToken(stub, domainName, userEmail, token)
+}
+
+// reserveName adds or replaces a name rule
+//
+// args: account, signature, kind, pattern, action, reason
+func (t *DNSChaincode) reserveName(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	kind := args[2]
+	pattern := strings.ToLower(args[3])
+	switch kind {
+	case RuleGlob:
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return nil, newError(CodeInvalidArgument, "Invalid glob %q: %s", pattern, err)
+		}
+	case RuleRegex:
+		pattern = args[3]
+		_, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, newError(CodeInvalidArgument, "Invalid regular expression %q: %s", pattern, err)
+		}
+	}
+	reason := ""
+	if len(args) > 5 {
+		reason = args[5]
+	}
+
+	row := shim.Row{
+		Columns: []*shim.Column{
+			{Value: &shim.Column_String_{String_: kind}},
+			{Value: &shim.Column_String_{String_: pattern}},
+			{Value: &shim.Column_String_{String_: args[4]}},
+			{Value: &shim.Column_String_{String_: reason}},
+			{Value: &shim.Column_String_{String_: args[0]}},
+		},
+	}
+	inserted, err := stub.InsertRow(reservedNamesTable, row)
+	if err == nil && !inserted {
+		_, err = stub.ReplaceRow(reservedNamesTable, row)
+	}
+	if err != nil {
+		return nil, newError(CodeInternal, "Error writing name rule: %s", err)
+	}
+	return nil, nil
+}
+
+// unreserveName removes a name rule
+//
+// args: account, signature, kind, pattern
+func (t *DNSChaincode) unreserveName(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	pattern := args[3]
+	if args[2] != RuleRegex {
+		pattern = strings.ToLower(pattern)
+	}
+	key := ruleKey(args[2], pattern)
+	row, err := stub.GetRow(reservedNamesTable, key)
+	if err != nil {
+		return nil, newError(CodeInternal, "Error reading name rules: %s", err)
+	}
+	if len(row.Columns) == 0 {
+		return nil, newError(CodeNotFound, "No %s rule %q", args[2], pattern)
+	}
+	err = stub.DeleteRow(reservedNamesTable, key)
+	if err != nil {
+		return nil, newError(CodeInternal, "Error deleting row: %s", err)
+	}
+	return nil, nil
+}
+
+// getReservations lists the name rules
+func (t *DNSChaincode) getReservations(stub *shim.ChaincodeStub) ([]*Reservation, error) {
+	rows, err := readAllRows(stub, reservedNamesTable)
+	if err != nil {
+		return nil, err
+	}
+	rules := []*Reservation{}
+	for _, row := range rows {
+		rules = append(rules, reservationOf(row))
+	}
+	return rules, nil
+}
+
+// checkName tells whether a name can be registered without an allocation
+// token, and if not, why
+//
+// args: domainName
+func (t *DNSChaincode) checkName(stub *shim.ChaincodeStub, args []string) (*NameCheck, error) {
+	result := &NameCheck{Domain: args[0]}
+	row, err := stub.GetRow("NameToIP", stringKey(args[0]))
+	if err != nil {
+		return nil, newError(CodeInternal, "Error reading domain: %s", err)
+	}
+	result.Registered = len(row.Columns) != 0
+	result.Rule, err = matchReservation(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	result.ConfusableWith, err = confusableDomain(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	result.Available = !result.Registered && result.Rule == nil && result.ConfusableWith == ""
+	return result, nil
+}
+
+// indexDomainSkeletons moves the tables to version 3 by indexing the
+// skeletons of the domains already registered. Of two registered look-alikes
+// the first in name order keeps the skeleton.
+func indexDomainSkeletons(stub *shim.ChaincodeStub) error {
+	// Init creates the table when the chaincode is deployed over older state;
+	// create it here too in case it was not
+	createDomainSkeletonsTable(stub)
+	rows, err := readAllRows(stub, "NameToIP")
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		err = indexSkeleton(stub, row.Columns[domainNameColumn].GetString_())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSkeletons compares the DomainSkeletons index with the registered
+// domains
+func checkSkeletons(tables TableReader, domains map[string]shim.Row, report *IntegrityReport) error {
+	rows, err := readAllRows(tables, domainSkeletonsTable)
+	if err != nil {
+		return err
+	}
+	indexed := make(map[string]string)
+	for _, row := range rows {
+		key, domainName := row.Columns[0].GetString_(), row.Columns[1].GetString_()
+		if _, ok := domains[domainName]; ok && skeleton(domainName) == key {
+			indexed[key] = domainName
+			continue
+		}
+		report.add(IssueSkeletonIndex, domainSkeletonsTable, key, func(stub *shim.ChaincodeStub) error {
+			return stub.DeleteRow(domainSkeletonsTable, stringKey(key))
+		}, "Skeleton of %s, which is not registered under it", domainName)
+	}
+
+	var names []string
+	for domainName := range domains {
+		names = append(names, domainName)
+	}
+	sort.Strings(names)
+	for _, domainName := range names {
+		key := skeleton(domainName)
+		if _, ok := indexed[key]; ok {
+			continue
+		}
+		indexed[key] = domainName
+		domainName := domainName
+		report.add(IssueSkeletonIndex, domainSkeletonsTable, key, func(stub *shim.ChaincodeStub) error {
+			return indexSkeleton(stub, domainName)
+		}, "Skeleton of %s is not indexed", domainName)
+	}
+	return nil
+}
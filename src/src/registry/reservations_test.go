@@ -0,0 +1,142 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"encoding/hex"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// allocationToken allocates domainName to userEmail until expires, signed by
+// administrator
+func (r *testRegistry) allocationToken(administrator string, domainName string, userEmail string, expires time.Time) string {
+	unix := strconv.FormatInt(expires.Unix(), 10)
+	signature := ed25519.Sign(r.keys[administrator], allocationMessage(domainName, userEmail, unix))
+	return administrator + "," + unix + "," + hex.EncodeToString(signature)
+}
+
+func (r *testRegistry) checkName(domainName string) NameCheck {
+	var check NameCheck
+	r.query("checkName", []string{domainName}, &check)
+	return check
+}
+
+func TestNameRules(t *testing.T) {
+	r := newAuctionRegistry(t)
+	if code := r.invoke("reserveName", "alice", RuleExact, "bank.com", ActionBlock); code != CodeUnauthorized {
+		t.Fatalf("Rule added by an account: expected %s, got %q", CodeUnauthorized, code)
+	}
+	if code := r.invoke("reserveName", testAdmin, RuleRegex, "(", ActionBlock); code != CodeInvalidArgument {
+		t.Fatalf("Invalid regular expression: expected %s, got %q", CodeInvalidArgument, code)
+	}
+	if code := r.invoke("reserveName", testAdmin, RuleGlob, "[", ActionBlock); code != CodeInvalidArgument {
+		t.Fatalf("Invalid glob: expected %s, got %q", CodeInvalidArgument, code)
+	}
+	r.mustInvoke("reserveName", testAdmin, RuleExact, "BANK.com", ActionBlock, "trademark")
+	r.mustInvoke("reserveName", testAdmin, RuleGlob, "*.gov", ActionBlock)
+	r.mustInvoke("reserveName", testAdmin, RuleRegex, "[0-9]+\\.com", ActionReserve)
+	r.mustInvoke("reserveName", testAdmin, RuleConfusable, "paypal.com", ActionBlock)
+	r.mustInvoke("reserveName", testAdmin, RuleGlob, "secret*.org", ActionReserve)
+	r.mustInvoke("reserveName", testAdmin, RuleExact, "secret.org", ActionBlock)
+
+	var rules []Reservation
+	r.query("getReservations", nil, &rules)
+	if len(rules) != 6 {
+		t.Fatalf("Expected 6 rules, got %+v", rules)
+	}
+
+	for i, domainName := range []string{"bank.com", "Bank.com", "irs.gov", "123.com", "paypa1.com", "secret.org", "secrets.org"} {
+		ip := "10.0.1." + strconv.Itoa(i+1)
+		if code := r.invoke("registerDomain", "alice", domainName, ip, "365"); code != CodeConflict {
+			t.Fatalf("Registering %s: expected %s, got %q", domainName, CodeConflict, code)
+		}
+	}
+	r.mustInvoke("registerDomain", "alice", "123.org", "10.0.0.1", "365")
+
+	check := r.checkName("123.com")
+	if check.Available || check.Rule == nil || check.Rule.Kind != RuleRegex || check.Rule.Action != ActionReserve {
+		t.Fatalf("Unexpected check of a reserved name: %+v", check)
+	}
+	// a blocking rule wins over a reserving one
+	check = r.checkName("secret.org")
+	if check.Available || check.Rule == nil || check.Rule.Action != ActionBlock {
+		t.Fatalf("Unexpected check of a blocked name: %+v", check)
+	}
+	if check = r.checkName("123.org"); check.Available || !check.Registered {
+		t.Fatalf("Unexpected check of a registered name: %+v", check)
+	}
+	if check = r.checkName("example.net"); !check.Available {
+		t.Fatalf("Unexpected check of a free name: %+v", check)
+	}
+
+	if code := r.invoke("unreserveName", testAdmin, RuleExact, "example.com"); code != CodeNotFound {
+		t.Fatalf("Removing a missing rule: expected %s, got %q", CodeNotFound, code)
+	}
+	r.mustInvoke("unreserveName", testAdmin, RuleExact, "Bank.COM")
+	r.mustInvoke("registerDomain", "alice", "bank.com", "10.0.0.2", "365")
+}
+
+func TestConfusableDomains(t *testing.T) {
+	r := newAuctionRegistry(t)
+	r.mustInvoke("registerDomain", "alice", "example.com", "10.0.0.1", "365")
+	for i, domainName := range []string{"examp1e.com", "ехample.com", "EXAMPLE.com"} {
+		ip := "10.0.1." + strconv.Itoa(i+1)
+		if code := r.invoke("registerDomain", "bob", domainName, ip, "365"); code != CodeConflict {
+			t.Fatalf("Registering %s: expected %s, got %q", domainName, CodeConflict, code)
+		}
+	}
+	if check := r.checkName("examp1e.com"); check.Available || check.ConfusableWith != "example.com" {
+		t.Fatalf("Unexpected check of a look-alike: %+v", check)
+	}
+
+	// the look-alike is free again once the domain is gone
+	r.mustInvoke("deleteDomain", "alice", "example.com")
+	r.mustInvoke("registerDomain", "bob", "examp1e.com", "10.0.0.2", "365")
+}
+
+func TestAllocationToken(t *testing.T) {
+	r := newAuctionRegistry(t)
+	r.mustInvoke("reserveName", testAdmin, RuleExact, "vip.com", ActionReserve)
+	expires := r.now.Add(time.Hour)
+
+	tokens := []struct {
+		name  string
+		token string
+		code  string
+	}{
+		{"No token", "", CodeConflict},
+		{"Malformed token", "admin", CodeInvalidArgument},
+		{"Token of another account", r.allocationToken(testAdmin, "vip.com", "bob", expires), CodeUnauthorized},
+		{"Token of another domain", r.allocationToken(testAdmin, "vip.org", "alice", expires), CodeUnauthorized},
+		{"Token signed by an account", r.allocationToken("bob", "vip.com", "alice", expires), CodeUnauthorized},
+		{"Expired token", r.allocationToken(testAdmin, "vip.com", "alice", r.now), CodeExpired},
+	}
+	for _, test := range tokens {
+		if code := r.invoke("registerDomain", "alice", "vip.com", "10.0.0.1", "365", defaultTTL, test.token); code != test.code {
+			t.Fatalf("%s: expected %s, got %q", test.name, test.code, code)
+		}
+	}
+	r.mustInvoke("registerDomain", "alice", "vip.com", "10.0.0.1", "365", defaultTTL,
+		r.allocationToken(testAdmin, "vip.com", "alice", expires))
+	if domain := r.domain("vip.com"); domain.Owner != "alice" {
+		t.Fatalf("Unexpected allocated domain: %+v", domain)
+	}
+}
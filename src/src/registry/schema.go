@@ -0,0 +1,399 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"encoding/hex"
+	"net"
+	"strconv"
+)
+
+// Every chaincode function declares its positional arguments here. Invoke and
+// Query validate the arguments against the schema before dispatching, so the
+// functions themselves can index args without checking the length, and the
+// describe query hands the same schemas to clients to generate bindings.
+
+// Argument types
+const (
+	ArgString = "string" // any non-empty string
+	ArgText   = "text"   // any string, may be empty
+	ArgHex    = "hex"    // hex encoded bytes
+	ArgUint   = "uint"   // non-negative integer
+	ArgNumber = "number" // non-negative decimal number
+	ArgIP     = "ip"     // IPv4 or IPv6 address
+
+	ArgPublicKey    = "publicKey"    // PEM public key, as text or hex encoded
+	ArgKeyAlgorithm = "keyAlgorithm" // one of the Alg constants
+	ArgStatusFlags  = "statusFlags"  // comma separated domain status flags
+	ArgRuleKind     = "ruleKind"     // one of the Rule constants
+	ArgRuleAction   = "ruleAction"   // one of the Action constants
+	ArgPermissions  = "permissions"  // comma separated operator permissions
+)
+
+// Function kinds
+const (
+	KindInvoke = "invoke"
+	KindQuery  = "query"
+)
+
+// ArgSpec describes one positional argument
+type ArgSpec struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Optional bool   `json:"optional,omitempty"`
+}
+
+// FunctionSchema describes the arguments of a chaincode function. When Signed
+// is set, args[0] is the calling account and args[1] is its hex encoded
+// signature over args[0]. When Bound is set as well, the signature is over
+// operationMessage instead, so it cannot be replayed. When Admin is set as
+// well, the calling account must be an administrator; every Admin invoke is
+// Bound, see check.
+type FunctionSchema struct {
+	Name   string    `json:"name"`
+	Kind   string    `json:"kind"`
+	Signed bool      `json:"signed"`
+	Bound  bool      `json:"bound,omitempty"`
+	Admin  bool      `json:"admin,omitempty"`
+	Args   []ArgSpec `json:"args"`
+}
+
+// signedArgs prefixes the arguments every signed function starts with
+func signedArgs(args ...ArgSpec) []ArgSpec {
+	return append([]ArgSpec{
+		{Name: "account", Type: ArgString},
+		{Name: "signature", Type: ArgHex},
+	}, args...)
+}
+
+// functionSchemas lists every function in the order describe returns them
+var functionSchemas = []*FunctionSchema{
+	{Name: "init", Kind: KindInvoke},
+	{Name: "createAccount", Kind: KindInvoke, Args: []ArgSpec{
+		{Name: "account", Type: ArgString},
+		{Name: "unused", Type: ArgText},
+		{Name: "publicKey", Type: ArgPublicKey},
+		{Name: "password", Type: ArgString},
+		{Name: "keyAlgorithm", Type: ArgKeyAlgorithm, Optional: true},
+	}},
+	{Name: "registerDomain", Kind: KindInvoke, Signed: true, Args: signedArgs(
+		ArgSpec{Name: "domainName", Type: ArgString},
+		ArgSpec{Name: "ipAddress", Type: ArgIP},
+		ArgSpec{Name: "durationDays", Type: ArgUint},
+		ArgSpec{Name: "ttlSeconds", Type: ArgUint, Optional: true},
+		ArgSpec{Name: "allocationToken", Type: ArgText, Optional: true},
+	)},
+	{Name: "transferDomain", Kind: KindInvoke, Signed: true, Bound: true, Args: signedArgs(
+		ArgSpec{Name: "domainName", Type: ArgString},
+		ArgSpec{Name: "newOwner", Type: ArgString},
+		ArgSpec{Name: "newIPAddress", Type: ArgIP},
+	)},
+	{Name: "updateDomain", Kind: KindInvoke, Signed: true, Args: signedArgs(
+		ArgSpec{Name: "domainName", Type: ArgString},
+		ArgSpec{Name: "ipAddress", Type: ArgIP},
+		ArgSpec{Name: "ttlSeconds", Type: ArgUint, Optional: true},
+	)},
+	{Name: "renewDomain", Kind: KindInvoke, Signed: true, Args: signedArgs(
+		ArgSpec{Name: "domainName", Type: ArgString},
+		ArgSpec{Name: "days", Type: ArgUint},
+	)},
+	{Name: "grantOperator", Kind: KindInvoke, Signed: true, Bound: true, Args: signedArgs(
+		ArgSpec{Name: "domainName", Type: ArgString},
+		ArgSpec{Name: "operator", Type: ArgString},
+		ArgSpec{Name: "permissions", Type: ArgPermissions},
+		ArgSpec{Name: "days", Type: ArgUint, Optional: true},
+	)},
+	{Name: "revokeOperator", Kind: KindInvoke, Signed: true, Args: signedArgs(
+		ArgSpec{Name: "domainName", Type: ArgString},
+		ArgSpec{Name: "operator", Type: ArgString},
+	)},
+	{Name: "deleteDomain", Kind: KindInvoke, Signed: true, Args: signedArgs(
+		ArgSpec{Name: "domainName", Type: ArgString},
+	)},
+	{Name: "lockDomain", Kind: KindInvoke, Signed: true, Bound: true, Args: signedArgs(
+		ArgSpec{Name: "domainName", Type: ArgString},
+		ArgSpec{Name: "flags", Type: ArgStatusFlags},
+	)},
+	{Name: "requestUnlock", Kind: KindInvoke, Signed: true, Bound: true, Args: signedArgs(
+		ArgSpec{Name: "domainName", Type: ArgString},
+		ArgSpec{Name: "flags", Type: ArgStatusFlags},
+	)},
+	{Name: "confirmUnlock", Kind: KindInvoke, Signed: true, Bound: true, Args: signedArgs(
+		ArgSpec{Name: "domainName", Type: ArgString},
+	)},
+	{Name: "cancelTransfer", Kind: KindInvoke, Signed: true, Args: signedArgs(
+		ArgSpec{Name: "domainName", Type: ArgString},
+	)},
+	{Name: "placeBid", Kind: KindInvoke, Signed: true, Args: signedArgs(
+		ArgSpec{Name: "owner", Type: ArgString},
+		ArgSpec{Name: "domainName", Type: ArgString},
+		ArgSpec{Name: "amount", Type: ArgUint},
+	)},
+	{Name: "withdraw", Kind: KindInvoke, Signed: true, Bound: true, Args: signedArgs(
+		ArgSpec{Name: "amount", Type: ArgUint},
+	)},
+	{Name: "bidAuction", Kind: KindInvoke, Signed: true, Bound: true, Args: signedArgs(
+		ArgSpec{Name: "domainName", Type: ArgString},
+		ArgSpec{Name: "amount", Type: ArgUint},
+	)},
+	{Name: "commitBid", Kind: KindInvoke, Signed: true, Bound: true, Args: signedArgs(
+		ArgSpec{Name: "domainName", Type: ArgString},
+		ArgSpec{Name: "commitment", Type: ArgHex},
+	)},
+	{Name: "revealBid", Kind: KindInvoke, Signed: true, Bound: true, Args: signedArgs(
+		ArgSpec{Name: "domainName", Type: ArgString},
+		ArgSpec{Name: "amount", Type: ArgUint},
+		ArgSpec{Name: "salt", Type: ArgString},
+	)},
+	{Name: "settleAuction", Kind: KindInvoke, Signed: true, Args: signedArgs(
+		ArgSpec{Name: "domainName", Type: ArgString},
+	)},
+	{Name: "createOrganization", Kind: KindInvoke, Signed: true, Args: signedArgs(
+		ArgSpec{Name: "orgName", Type: ArgString},
+		ArgSpec{Name: "members", Type: ArgText},
+		ArgSpec{Name: "defaultThreshold", Type: ArgUint},
+	)},
+	{Name: "proposeOrgAction", Kind: KindInvoke, Signed: true, Args: signedArgs(
+		ArgSpec{Name: "orgName", Type: ArgString},
+		ArgSpec{Name: "action", Type: ArgString},
+		ArgSpec{Name: "actionArgs", Type: ArgString},
+	)},
+	{Name: "approveOrgProposal", Kind: KindInvoke, Signed: true, Args: signedArgs(
+		ArgSpec{Name: "proposalID", Type: ArgString},
+	)},
+	{Name: "cancelOrgProposal", Kind: KindInvoke, Signed: true, Args: signedArgs(
+		ArgSpec{Name: "proposalID", Type: ArgString},
+	)},
+	{Name: "rotateKey", Kind: KindInvoke, Signed: true, Bound: true, Args: signedArgs(
+		ArgSpec{Name: "newPublicKey", Type: ArgPublicKey},
+		ArgSpec{Name: "newKeySignature", Type: ArgHex},
+		ArgSpec{Name: "keyAlgorithm", Type: ArgKeyAlgorithm, Optional: true},
+	)},
+	{Name: "setRecoveryKeys", Kind: KindInvoke, Signed: true, Bound: true, Args: signedArgs(
+		ArgSpec{Name: "threshold", Type: ArgUint},
+		ArgSpec{Name: "recoveryKeys", Type: ArgText},
+	)},
+	{Name: "recoverAccount", Kind: KindInvoke, Args: []ArgSpec{
+		{Name: "account", Type: ArgString},
+		{Name: "newPublicKey", Type: ArgPublicKey},
+		{Name: "signatures", Type: ArgText},
+		{Name: "keyAlgorithm", Type: ArgKeyAlgorithm, Optional: true},
+	}},
+	{Name: "closeAccount", Kind: KindInvoke, Signed: true, Bound: true, Args: signedArgs()},
+	{Name: "migrate", Kind: KindInvoke, Signed: true, Bound: true, Admin: true, Args: signedArgs(
+		ArgSpec{Name: "targetVersion", Type: ArgUint, Optional: true},
+	)},
+	{Name: "addAdministrator", Kind: KindInvoke, Signed: true, Bound: true, Admin: true, Args: signedArgs(
+		ArgSpec{Name: "administrator", Type: ArgString},
+	)},
+	{Name: "removeAdministrator", Kind: KindInvoke, Signed: true, Bound: true, Admin: true, Args: signedArgs(
+		ArgSpec{Name: "administrator", Type: ArgString},
+	)},
+	{Name: "allowStateCopy", Kind: KindInvoke, Signed: true, Bound: true, Admin: true, Args: signedArgs(
+		ArgSpec{Name: "allowed", Type: ArgString},
+	)},
+	{Name: "setTransferWindow", Kind: KindInvoke, Signed: true, Bound: true, Admin: true, Args: signedArgs(
+		ArgSpec{Name: "days", Type: ArgUint},
+	)},
+	{Name: "deposit", Kind: KindInvoke, Signed: true, Bound: true, Admin: true, Args: signedArgs(
+		ArgSpec{Name: "depositor", Type: ArgString},
+		ArgSpec{Name: "amount", Type: ArgUint},
+	)},
+	{Name: "startEnglishAuction", Kind: KindInvoke, Signed: true, Bound: true, Admin: true, Args: signedArgs(
+		ArgSpec{Name: "domainName", Type: ArgString},
+		ArgSpec{Name: "reserve", Type: ArgUint},
+		ArgSpec{Name: "minIncrement", Type: ArgUint},
+		ArgSpec{Name: "durationSeconds", Type: ArgUint},
+		ArgSpec{Name: "extensionSeconds", Type: ArgUint},
+	)},
+	{Name: "startSealedAuction", Kind: KindInvoke, Signed: true, Bound: true, Admin: true, Args: signedArgs(
+		ArgSpec{Name: "domainName", Type: ArgString},
+		ArgSpec{Name: "reserve", Type: ArgUint},
+		ArgSpec{Name: "commitSeconds", Type: ArgUint},
+		ArgSpec{Name: "revealSeconds", Type: ArgUint},
+	)},
+	{Name: "cancelAuction", Kind: KindInvoke, Signed: true, Bound: true, Admin: true, Args: signedArgs(
+		ArgSpec{Name: "domainName", Type: ArgString},
+	)},
+	{Name: "reserveName", Kind: KindInvoke, Signed: true, Bound: true, Admin: true, Args: signedArgs(
+		ArgSpec{Name: "kind", Type: ArgRuleKind},
+		ArgSpec{Name: "pattern", Type: ArgString},
+		ArgSpec{Name: "action", Type: ArgRuleAction},
+		ArgSpec{Name: "reason", Type: ArgText, Optional: true},
+	)},
+	{Name: "unreserveName", Kind: KindInvoke, Signed: true, Bound: true, Admin: true, Args: signedArgs(
+		ArgSpec{Name: "kind", Type: ArgRuleKind},
+		ArgSpec{Name: "pattern", Type: ArgString},
+	)},
+	{Name: "repair", Kind: KindInvoke, Signed: true, Bound: true, Admin: true, Args: signedArgs(
+		ArgSpec{Name: "kinds", Type: ArgText, Optional: true},
+	)},
+
+	{Name: "describe", Kind: KindQuery},
+	{Name: "getSchemaVersion", Kind: KindQuery},
+	{Name: "checkIntegrity", Kind: KindQuery, Signed: true, Admin: true, Args: signedArgs()},
+	{Name: "query_stats", Kind: KindQuery, Args: []ArgSpec{
+		{Name: "expiryWindowDays", Type: ArgUint, Optional: true},
+	}},
+	{Name: "checkAccount", Kind: KindQuery, Signed: true, Args: signedArgs(
+		ArgSpec{Name: "password", Type: ArgString},
+	)},
+	{Name: "getDomainName", Kind: KindQuery, Args: []ArgSpec{
+		{Name: "ipAddress", Type: ArgIP},
+	}},
+	{Name: "getIPAddress", Kind: KindQuery, Args: []ArgSpec{
+		{Name: "domainName", Type: ArgString},
+	}},
+	{Name: "getDomain", Kind: KindQuery, Args: []ArgSpec{
+		{Name: "domainName", Type: ArgString},
+	}},
+	{Name: "getOwnedDomains", Kind: KindQuery, Signed: true, Args: signedArgs()},
+	{Name: "getOwnedBids", Kind: KindQuery, Signed: true, Args: signedArgs()},
+	{Name: "getTransferRequests", Kind: KindQuery, Signed: true, Args: signedArgs()},
+	{Name: "getAuction", Kind: KindQuery, Args: []ArgSpec{
+		{Name: "domainName", Type: ArgString},
+	}},
+	{Name: "getBalance", Kind: KindQuery, Signed: true, Args: signedArgs()},
+	{Name: "getNonce", Kind: KindQuery, Args: []ArgSpec{
+		{Name: "account", Type: ArgString},
+	}},
+	{Name: "getReservations", Kind: KindQuery},
+	{Name: "getOperators", Kind: KindQuery, Args: []ArgSpec{
+		{Name: "domainName", Type: ArgString},
+	}},
+	{Name: "getOrganization", Kind: KindQuery, Args: []ArgSpec{
+		{Name: "orgName", Type: ArgString},
+	}},
+	{Name: "getOrgProposals", Kind: KindQuery, Args: []ArgSpec{
+		{Name: "orgName", Type: ArgString},
+		{Name: "all", Type: ArgText, Optional: true},
+	}},
+	{Name: "checkName", Kind: KindQuery, Args: []ArgSpec{
+		{Name: "domainName", Type: ArgString},
+	}},
+}
+
+// lookupSchema returns the schema of a function of the given kind
+func lookupSchema(kind string, function string) (*FunctionSchema, error) {
+	for _, schema := range functionSchemas {
+		if schema.Kind == kind && schema.Name == function {
+			return schema, nil
+		}
+	}
+	return nil, newError(CodeInvalidArgument, "Received unknown function %s %s", kind, function)
+}
+
+func init() {
+	for _, schema := range functionSchemas {
+		err := schema.check()
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// check rejects a schema whose signature could be replayed. A signature over
+// args[0] alone never changes, so anyone who has seen one administrator call
+// could repeat any other with different arguments; Admin invokes must be
+// Bound. Queries change nothing and have no nonce to bind to.
+func (s *FunctionSchema) check() error {
+	if (s.Bound || s.Admin) && !s.Signed {
+		return newError(CodeInternal, "%s: Bound and Admin functions must be Signed", s.Name)
+	}
+	if s.Kind == KindInvoke && s.Admin && !s.Bound {
+		return newError(CodeInternal, "%s: Admin invokes must be Bound", s.Name)
+	}
+	if s.Kind == KindQuery && s.Bound {
+		return newError(CodeInternal, "%s: queries cannot be Bound", s.Name)
+	}
+	return nil
+}
+
+// validate checks args against the schema
+func (s *FunctionSchema) validate(args []string) error {
+	required := 0
+	for _, spec := range s.Args {
+		if !spec.Optional {
+			required++
+		}
+	}
+	if len(args) < required || len(args) > len(s.Args) {
+		if required == len(s.Args) {
+			return newError(CodeInvalidArgument, "%s: incorrect number of arguments. Expecting %d", s.Name, required)
+		}
+		return newError(CodeInvalidArgument, "%s: incorrect number of arguments. Expecting %d to %d", s.Name, required, len(s.Args))
+	}
+
+	for i, arg := range args {
+		spec := s.Args[i]
+		if !validArg(spec.Type, arg) {
+			return newError(CodeInvalidArgument, "%s: argument %d (%s) must be of type %s", s.Name, i, spec.Name, spec.Type)
+		}
+	}
+	return nil
+}
+
+func validArg(argType string, arg string) bool {
+	switch argType {
+	case ArgText:
+		return true
+	case ArgString:
+		return arg != ""
+	case ArgHex:
+		_, err := hex.DecodeString(arg)
+		return arg != "" && err == nil
+	case ArgUint:
+		_, err := strconv.ParseUint(arg, 10, 64)
+		return err == nil
+	case ArgNumber:
+		value, err := strconv.ParseFloat(arg, 64)
+		return err == nil && value >= 0
+	case ArgIP:
+		return net.ParseIP(arg) != nil
+	case ArgPublicKey:
+		key, err := decodePublicKey(arg)
+		return len(key) != 0 && err == nil
+	case ArgKeyAlgorithm:
+		return isKeyAlgorithm(arg)
+	case ArgStatusFlags:
+		flags := splitList(arg)
+		for _, flag := range flags {
+			if !isDomainStatus(flag) {
+				return false
+			}
+		}
+		return len(flags) != 0
+	case ArgPermissions:
+		permissions := splitList(arg)
+		for _, permission := range permissions {
+			if !isPermission(permission) {
+				return false
+			}
+		}
+		return len(permissions) != 0
+	case ArgRuleKind:
+		return isRuleKind(arg)
+	case ArgRuleAction:
+		return isRuleAction(arg)
+	}
+	return false
+}
+
+// describe returns the schemas of every function
+func (t *DNSChaincode) describe() []*FunctionSchema {
+	return functionSchemas
+}
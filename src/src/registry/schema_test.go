@@ -0,0 +1,108 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"testing"
+)
+
+func TestSchemaCheck(t *testing.T) {
+	for _, schema := range []*FunctionSchema{
+		{Name: "admin", Kind: KindInvoke, Signed: true, Admin: true},
+		{Name: "unsigned", Kind: KindInvoke, Bound: true},
+		{Name: "boundQuery", Kind: KindQuery, Signed: true, Bound: true},
+	} {
+		if schema.check() == nil {
+			t.Fatalf("Accepted schema %+v", schema)
+		}
+	}
+	for _, schema := range []*FunctionSchema{
+		{Name: "admin", Kind: KindInvoke, Signed: true, Bound: true, Admin: true},
+		{Name: "adminQuery", Kind: KindQuery, Signed: true, Admin: true},
+	} {
+		if err := schema.check(); err != nil {
+			t.Fatalf("Rejected schema %+v: %s", schema, err)
+		}
+	}
+}
+
+func TestAdministratorSignaturesCannotBeReplayed(t *testing.T) {
+	r := newTestRegistry(t)
+	r.createAccount("alice")
+	add := r.signedArgs("addAdministrator", testAdmin, "alice")
+	if code := r.invokeArgs("addAdministrator", add); code != "" {
+		t.Fatalf("Adding an administrator failed: %s", code)
+	}
+	r.mustInvoke("removeAdministrator", testAdmin, "alice")
+	if code := r.invokeArgs("addAdministrator", add); code != CodeUnauthorized {
+		t.Fatalf("Replayed addAdministrator: expected %s, got %q", CodeUnauthorized, code)
+	}
+	// the signature only covers the account it was made for
+	add = r.signedArgs("addAdministrator", testAdmin, "alice")
+	add[2] = "bob"
+	if code := r.invokeArgs("addAdministrator", add); code != CodeUnauthorized {
+		t.Fatalf("addAdministrator for another account: expected %s, got %q", CodeUnauthorized, code)
+	}
+}
+
+func TestValidateArgs(t *testing.T) {
+	registerDomain, _ := lookupSchema(KindInvoke, "registerDomain")
+	grantOperator, _ := lookupSchema(KindInvoke, "grantOperator")
+	lockDomain, _ := lookupSchema(KindInvoke, "lockDomain")
+	for _, c := range []struct {
+		schema *FunctionSchema
+		args   []string
+		valid  bool
+	}{
+		{registerDomain, []string{"alice", "00", "example.com", "10.0.0.1", "365"}, true},
+		{registerDomain, []string{"alice", "00", "example.com", "::1", "365", "300", ""}, true},
+		{registerDomain, []string{"alice", "00", "example.com", "10.0.0.1"}, false},
+		{registerDomain, []string{"alice", "00", "example.com", "10.0.0.1", "365", "300", "", "extra"}, false},
+		{registerDomain, []string{"", "00", "example.com", "10.0.0.1", "365"}, false},
+		{registerDomain, []string{"alice", "0g", "example.com", "10.0.0.1", "365"}, false},
+		{registerDomain, []string{"alice", "00", "example.com", "10.0.0", "365"}, false},
+		{registerDomain, []string{"alice", "00", "example.com", "10.0.0.1", "-1"}, false},
+		{grantOperator, []string{"alice", "00", "example.com", "bob", PermRecords + "," + PermRenew}, true},
+		{grantOperator, []string{"alice", "00", "example.com", "bob", "delete"}, false},
+		{lockDomain, []string{"alice", "00", "example.com", StatusTransferProhibited}, true},
+		{lockDomain, []string{"alice", "00", "example.com", ""}, false},
+	} {
+		err := c.schema.validate(c.args)
+		if c.valid && err != nil {
+			t.Fatalf("%s%v rejected: %s", c.schema.Name, c.args, err)
+		}
+		if !c.valid && (err == nil || asChaincodeError(err).Code != CodeInvalidArgument) {
+			t.Fatalf("%s%v: expected %s, got %v", c.schema.Name, c.args, CodeInvalidArgument, err)
+		}
+	}
+}
+
+func TestInvalidArgsAreRejectedBeforeRunning(t *testing.T) {
+	r := newAuctionRegistry(t)
+	if code := r.invoke("registerDomain", "alice", "example.com", "not an address", "365"); code != CodeInvalidArgument {
+		t.Fatalf("Invalid address: expected %s, got %q", CodeInvalidArgument, code)
+	}
+	if code := r.queryCode("getDomain", []string{"example.com"}); code != CodeNotFound {
+		t.Fatalf("Domain registered with an invalid address: %q", code)
+	}
+
+	var schemas []*FunctionSchema
+	r.query("describe", nil, &schemas)
+	if len(schemas) != len(functionSchemas) || schemas[0].Name != "init" {
+		t.Fatalf("Unexpected schemas from describe: %d", len(schemas))
+	}
+}
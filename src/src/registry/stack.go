@@ -0,0 +1,1275 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+
+Contributors:
+ Justin E. Ervin - Initial implementation
+*/
+
+package registry
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"math/rand"
+	"encoding/json"
+	
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// DNSChaincode is the DNS registry. It is started as a user chaincode by the
+// main package and can be registered as a system chaincode with the peer.
+type DNSChaincode struct {
+}
+
+// timeFormat is the layout of every date stored in the tables. It keeps the
+// full precision of the transaction time, so that the delays and windows
+// checked against these dates hold to the nanosecond.
+const timeFormat = time.RFC3339Nano
+
+// legacyTimeFormat is the minute precision layout of the dates stored before
+// timeFormat, which parseTime still reads
+const legacyTimeFormat = "02 Jan 06 15:04 MST"
+
+// parseTime parses a date stored in the tables in either layout
+func parseTime(value string) (time.Time, error) {
+	parsed, err := time.Parse(timeFormat, value)
+	if err != nil {
+		parsed, err = time.Parse(legacyTimeFormat, value)
+	}
+	return parsed, err
+}
+
+// Dates are stored in INT64 columns as Unix times in nanoseconds, so that
+// they keep the precision of timeFormat; 0 stands for no date. Payloads show
+// them in timeFormat.
+func dateColumn(value time.Time) *shim.Column {
+	if value.IsZero() {
+		return &shim.Column{Value: &shim.Column_Int64{Int64: 0}}
+	}
+	return &shim.Column{Value: &shim.Column_Int64{Int64: value.UnixNano()}}
+}
+
+// columnDate reads a column written by dateColumn. ok is false if the column
+// holds no date.
+func columnDate(column *shim.Column) (time.Time, bool) {
+	if column.GetInt64() == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, column.GetInt64()).UTC(), true
+}
+
+// formatDate formats a column written by dateColumn, empty if it holds no
+// date
+func formatDate(column *shim.Column) string {
+	date, ok := columnDate(column)
+	if !ok {
+		return ""
+	}
+	return date.Format(timeFormat)
+}
+
+// daysColumn holds the duration of a registration, in days
+func daysColumn(days uint64) *shim.Column {
+	return &shim.Column{Value: &shim.Column_Uint64{Uint64: days}}
+}
+
+// columns of the NameToIP table
+const (
+	domainNameColumn = iota
+	domainIPColumn
+	domainOwnerColumn
+	domainRegisteredColumn
+	domainDurationColumn
+	domainExpiryColumn
+	domainTTLColumn
+	domainStatusColumn
+	domainPendingUnlockColumn
+	domainUnlockRequestedColumn
+)
+
+// defaultTTL is the TTL, in seconds, of a domain registered without one
+const defaultTTL = "3600"
+
+type DomainName struct {
+	userEmail 	string `json:"userEmail"`
+	address 	string `json:"ipAddress"`
+}
+
+// Domain is the payload returned by getDomain
+type Domain struct {
+	Name          string   `json:"domainName"`
+	IPAddress     string   `json:"ipAddress"`
+	Owner         string   `json:"owner"`
+	Registered    string   `json:"registered"`
+	DurationDays  string   `json:"durationDays"`
+	Expiry        string   `json:"expiry"`
+	TTL           string   `json:"ttl"`
+	Status        []string `json:"status"`
+	PendingUnlock []string `json:"pendingUnlock,omitempty"`
+	UnlockAt      string   `json:"unlockAt,omitempty"`
+	TransferTo    string   `json:"transferTo,omitempty"`
+	TransferAt    string   `json:"transferAt,omitempty"`
+}
+
+type IPAddress struct {
+	userEmail 	string `json:"userEmail"`
+	domainName 	string `json:"domainName"`
+}
+
+type account struct {
+	email 				string 	`json:"email"`
+	registrationDate 	time.Time 	`json:"reg_date"`
+	pubKey 				string 	`json:"pub_key"`
+	password 			string 	`json:"passwd"`
+}
+// Init resets all the things. The peer deploys system chaincodes without a
+// function name, so an empty name initializes the tables as well.
+//
+// The deploy may pass createAccount arguments to bootstrap the first
+// administrator. Tables that already exist, for instance in state copied
+// from an older chaincode, are left at their schema version for migrate.
+func (t *DNSChaincode) Init(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	if function == "init" || function == "" {
+		var err error
+		_, err = stub.GetTable("NameToIP")
+		fresh := err == shim.ErrTableNotFound
+
+		err = stub.CreateTable("NameToIP", []*shim.ColumnDefinition{
+			{"domainName", shim.ColumnDefinition_STRING, true, false},
+			{"ipAddress", shim.ColumnDefinition_STRING, false, false},
+			{"userEmail", shim.ColumnDefinition_STRING, false, false},
+			{"DateRegistered", shim.ColumnDefinition_INT64, false, false},
+			{"Duration", shim.ColumnDefinition_UINT64, false, false},
+			{"Expiry", shim.ColumnDefinition_INT64, false, false},
+			{"TTL", shim.ColumnDefinition_STRING, false, false},
+			{"Status", shim.ColumnDefinition_STRING, false, false},
+			{"PendingUnlock", shim.ColumnDefinition_STRING, false, false},
+			{"UnlockRequested", shim.ColumnDefinition_INT64, false, false},
+		})
+		if err != nil {
+			fmt.Println("Error creating table: ", err)
+		}
+
+		err = stub.CreateTable("IPToName", []*shim.ColumnDefinition{
+			{"ipAddress", shim.ColumnDefinition_STRING, true, false},
+			{"domainName", shim.ColumnDefinition_STRING, false, false},
+			{"userEmail", shim.ColumnDefinition_STRING, false, false},
+			{"DateRegistered", shim.ColumnDefinition_INT64, false, false},
+			{"Duration", shim.ColumnDefinition_UINT64, false, false},
+		})
+		if err != nil {
+			fmt.Println("Error creating table: ", err)
+		}
+
+		err = stub.CreateTable("TransferRequests", []*shim.ColumnDefinition{
+			{"RequestID", shim.ColumnDefinition_STRING, true, false},
+			{"Owner", shim.ColumnDefinition_STRING, false, true},
+			{"Buyer", shim.ColumnDefinition_STRING, false, false},
+			{"BidValue", shim.ColumnDefinition_UINT64, false, false},
+			{"Status", shim.ColumnDefinition_STRING, false, false},
+			{"DateRequested", shim.ColumnDefinition_INT64, false, false},
+			{"DateDecision", shim.ColumnDefinition_INT64, false, false},
+			{"DomainName", shim.ColumnDefinition_STRING, false, false},
+		})
+		if err != nil {
+			fmt.Println("Error creating table: ", err)
+		}
+
+		err = stub.CreateTable("RegisteredUsers", []*shim.ColumnDefinition{
+			{"userEmail", shim.ColumnDefinition_STRING, true, false},
+			{"PubKey", shim.ColumnDefinition_STRING, false, false},
+			{"Password", shim.ColumnDefinition_STRING, false, false},
+			{"RegistrationDate", shim.ColumnDefinition_INT64, false, false},
+			{"DomainOwned", shim.ColumnDefinition_STRING, false, false},
+			{"RequestedBids", shim.ColumnDefinition_STRING, false, false},
+			{"OwnedBids", shim.ColumnDefinition_STRING, false, false},
+		})
+		if err != nil {
+			fmt.Println("Error creating table: ", err)
+		}
+
+		err = createStatsTable(stub)
+		if err != nil {
+			fmt.Println("Error creating table: ", err)
+		}
+
+		err = createRecoveryTable(stub)
+		if err != nil {
+			fmt.Println("Error creating table: ", err)
+		}
+
+		err = createNonceTable(stub)
+		if err != nil {
+			fmt.Println("Error creating table: ", err)
+		}
+
+		err = createKeyAlgorithmTable(stub)
+		if err != nil {
+			fmt.Println("Error creating table: ", err)
+		}
+
+		err = createAdministratorsTable(stub)
+		if err != nil {
+			fmt.Println("Error creating table: ", err)
+		}
+
+		err = createPendingTransfersTable(stub)
+		if err != nil {
+			fmt.Println("Error creating table: ", err)
+		}
+
+		err = createEscrowTable(stub)
+		if err != nil {
+			fmt.Println("Error creating table: ", err)
+		}
+
+		err = createAuctionTables(stub)
+		if err != nil {
+			fmt.Println("Error creating table: ", err)
+		}
+
+		err = createReservationTables(stub)
+		if err != nil {
+			fmt.Println("Error creating table: ", err)
+		}
+
+		err = createOrganizationTables(stub)
+		if err != nil {
+			fmt.Println("Error creating table: ", err)
+		}
+
+		err = createDomainOperatorsTable(stub)
+		if err != nil {
+			fmt.Println("Error creating table: ", err)
+		}
+
+		if fresh {
+			err = writeSchemaVersion(stub, latestSchemaVersion)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(args) != 0 {
+			err = t.bootstrapAdministrator(stub, args)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (t *DNSChaincode) getUserPubKey(stub *shim.ChaincodeStub, args []string) (string) {
+	userEmail := args[0]
+	accountRow, accountErr := stub.GetRow("RegisteredUsers", []shim.Column{{Value: &shim.Column_String_{String_: userEmail}}})
+	if accountErr != nil {
+		return ""	
+	} else if len(accountRow.Columns) == 0 {
+		return ""	
+	} else {
+		return accountRow.Columns[1].GetString_()
+	}
+}
+
+func (t *DNSChaincode) checkUserPrivKey(stub *shim.ChaincodeStub, args []string) (bool, error) {
+	pubKey := t.getUserPubKey(stub,args)
+	if pubKey == "" {
+		return false, newError(CodeNotFound, "Account does not exist")
+	}
+	algorithm, err := getKeyAlgorithm(stub, args[0])
+	if err != nil {
+		return false, err
+	}
+	return t.verifySignature(pubKey, algorithm, []byte(args[0]), args[1])
+}
+
+// checkOperationSignature checks the signature of a call to a Bound function,
+// which is over the operationMessage of the call
+func (t *DNSChaincode) checkOperationSignature(stub *shim.ChaincodeStub, function string, args []string) (bool, error) {
+	pubKey := t.getUserPubKey(stub, args)
+	if pubKey == "" {
+		return false, newError(CodeNotFound, "Account does not exist")
+	}
+	algorithm, err := getKeyAlgorithm(stub, args[0])
+	if err != nil {
+		return false, err
+	}
+	nonce, err := getAccountNonce(stub, args[0])
+	if err != nil {
+		return false, err
+	}
+	return t.verifySignature(pubKey, algorithm, operationMessage(function, args[0], nonce, args[2:]), args[1])
+}
+
+// verifySignature checks a hex encoded signature over message against a hex
+// encoded PEM public key. An empty algorithm picks the default for the key.
+func (t *DNSChaincode) verifySignature(pubKey string, algorithm string, message []byte, signature string) (bool, error) {
+	signByte, _ := hex.DecodeString(signature)
+	pubByte, _ := decodePublicKey(pubKey)
+	key, keyError := t.parsePublicKey(pubByte, algorithm)
+	if keyError == nil {
+		return key.Unsign(message, signByte) == nil, nil
+	}
+	return false, keyError
+}
+
+// Invoke is our entry point to invoke a chaincode function. Every error it
+// returns is a ChaincodeError.
+func (t *DNSChaincode) Invoke(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	result, err := t.invoke(stub, function, args)
+	if err != nil {
+		takeEvents(stub)
+		return nil, asChaincodeError(err)
+	}
+	err = flushEvents(stub)
+	if err != nil {
+		return nil, asChaincodeError(err)
+	}
+	return result, nil
+}
+
+func (t *DNSChaincode) invoke(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	fmt.Println("invoke is running " + function)
+
+	schema, err := lookupSchema(KindInvoke, function)
+	if err != nil {
+		return nil, err
+	}
+	err = schema.validate(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if function != "init" && function != "migrate" {
+		err = checkSchemaVersion(stub)
+		if err != nil {
+			return nil, err
+		}
+		err = finalizeTransfers(stub)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if function == "init" {
+		return t.Init(stub, "init", args)
+	} else if function == "createAccount" {
+		return t.createAccount(stub, args)
+	} else if function == "recoverAccount" {
+		return t.recoverAccount(stub, args)
+	}
+
+	if schema.Signed {
+		var check bool
+		if schema.Bound {
+			check, err = t.checkOperationSignature(stub, function, args)
+		} else {
+			check, err = t.checkUserPrivKey(stub,args)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !check {
+			return nil, newError(CodeUnauthorized, "Signed by wrong private key")
+		}
+	}
+	if schema.Admin {
+		err = requireAdministrator(stub, args[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := t.dispatch(stub, function, args)
+	if err != nil {
+		return nil, err
+	}
+	if schema.Bound {
+		err = useAccountNonce(stub, args[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// dispatch runs an invoke function whose arguments have been checked. An
+// organisation proposal runs here too, with the organisation as args[0].
+func (t *DNSChaincode) dispatch(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	// Handle different functions
+	if function == "registerDomain" {
+		return t.registerDomain(stub, args)
+	} else if function == "transferDomain" {
+		return t.transferDomain(stub, args)
+	} else if function == "placeBid" {
+		return t.placeBid(stub, args)
+	} else if function == "updateDomain" {
+		return t.updateDomain(stub, args)
+	} else if function == "deleteDomain" {
+		return t.deleteDomain(stub, args)
+	} else if function == "lockDomain" {
+		return t.lockDomain(stub, args)
+	} else if function == "requestUnlock" {
+		return t.requestUnlock(stub, args)
+	} else if function == "confirmUnlock" {
+		return t.confirmUnlock(stub, args)
+	} else if function == "rotateKey" {
+		return t.rotateKey(stub, args)
+	} else if function == "setRecoveryKeys" {
+		return t.setRecoveryKeys(stub, args)
+	} else if function == "closeAccount" {
+		return t.closeAccount(stub, args)
+	} else if function == "migrate" {
+		return t.migrate(stub, args)
+	} else if function == "addAdministrator" {
+		return t.addAdministrator(stub, args)
+	} else if function == "removeAdministrator" {
+		return t.removeAdministrator(stub, args)
+	} else if function == "repair" {
+		return t.repair(stub, args)
+	} else if function == "cancelTransfer" {
+		return t.cancelTransfer(stub, args)
+	} else if function == "allowStateCopy" {
+		return t.allowStateCopy(stub, args)
+	} else if function == "setTransferWindow" {
+		return t.setTransferWindow(stub, args)
+	} else if function == "deposit" {
+		return t.deposit(stub, args)
+	} else if function == "withdraw" {
+		return t.withdraw(stub, args)
+	} else if function == "startEnglishAuction" {
+		return t.startEnglishAuction(stub, args)
+	} else if function == "startSealedAuction" {
+		return t.startSealedAuction(stub, args)
+	} else if function == "bidAuction" {
+		return t.bidAuction(stub, args)
+	} else if function == "commitBid" {
+		return t.commitBid(stub, args)
+	} else if function == "revealBid" {
+		return t.revealBid(stub, args)
+	} else if function == "settleAuction" {
+		return t.settleAuction(stub, args)
+	} else if function == "cancelAuction" {
+		return t.cancelAuction(stub, args)
+	} else if function == "reserveName" {
+		return t.reserveName(stub, args)
+	} else if function == "unreserveName" {
+		return t.unreserveName(stub, args)
+	} else if function == "createOrganization" {
+		return t.createOrganization(stub, args)
+	} else if function == "proposeOrgAction" {
+		return t.proposeOrgAction(stub, args)
+	} else if function == "approveOrgProposal" {
+		return t.approveOrgProposal(stub, args)
+	} else if function == "cancelOrgProposal" {
+		return t.cancelOrgProposal(stub, args)
+	} else if function == "grantOperator" {
+		return t.grantOperator(stub, args)
+	} else if function == "revokeOperator" {
+		return t.revokeOperator(stub, args)
+	} else if function == "renewDomain" {
+		return t.renewDomain(stub, args)
+	}
+
+	fmt.Println("invoke did not find function: " + function)
+	return nil, newError(CodeInvalidArgument, "Received unknown function invocation")
+}
+func (t *DNSChaincode) checkAccount(stub *shim.ChaincodeStub, args []string) (bool, error) {
+	row, rowErr := stub.GetRow("RegisteredUsers", []shim.Column{{Value: &shim.Column_String_{String_: args[0]}}})
+	if rowErr != nil || len(row.Columns) == 0 {
+		fmt.Println(fmt.Sprintf("[ERROR] Could not retrieve the rows: %s", rowErr))
+		return false, newError(CodeNotFound, "Account does not exist")
+	}
+	if row.Columns[2].GetString_() != args[2] {
+		return false, newError(CodeUnauthorized, "Password is incorrect.")
+	}  
+	check, err := t.checkUserPrivKey(stub,args)
+	fmt.Println(check)
+	if err!=nil {
+		return false, err
+	}
+	return check, nil
+}
+func (t *DNSChaincode) getDomainName(stub *shim.ChaincodeStub, args []string) (string, error) {
+	ipAddress := args[0]
+	ipRow, ipErr := stub.GetRow("IPToName", []shim.Column{{Value: &shim.Column_String_{String_: ipAddress}}})
+	if ipErr != nil || len(ipRow.Columns) == 0 {
+		return "", newError(CodeNotFound, "Error occurred in getting Domain name. Probably IP address is not assigned to any Domain")
+	} else {
+		return ipRow.Columns[1].GetString_(), nil
+	}
+}
+func (t *DNSChaincode) getIPAddress(stub *shim.ChaincodeStub, args []string) (string, error) {
+	domainName := args[0]
+	domainRow, domainErr := stub.GetRow("NameToIP", []shim.Column{{Value: &shim.Column_String_{String_: domainName}}})
+	if domainErr != nil || len(domainRow.Columns) == 0 {
+		return "", newError(CodeNotFound, "Error occurred in getting IP Address. Probably domain name is not registered")
+	} else {
+		return domainRow.Columns[1].GetString_(), nil
+	}
+}
+// getDomain returns the full NameToIP entry of a domain
+func (t *DNSChaincode) getDomain(stub *shim.ChaincodeStub, args []string) (*Domain, error) {
+	err := checkSchemaVersion(stub)
+	if err != nil {
+		return nil, err
+	}
+	domainRow, domainErr := stub.GetRow("NameToIP", []shim.Column{{Value: &shim.Column_String_{String_: args[0]}}})
+	if domainErr != nil || len(domainRow.Columns) == 0 {
+		return nil, newError(CodeNotFound, "Domain %s is not registered", args[0])
+	}
+	domain := &Domain{
+		Name:          domainRow.Columns[domainNameColumn].GetString_(),
+		IPAddress:     domainRow.Columns[domainIPColumn].GetString_(),
+		Owner:         domainRow.Columns[domainOwnerColumn].GetString_(),
+		Registered:    formatDate(domainRow.Columns[domainRegisteredColumn]),
+		DurationDays:  strconv.FormatUint(domainRow.Columns[domainDurationColumn].GetUint64(), 10),
+		Expiry:        formatDate(domainRow.Columns[domainExpiryColumn]),
+		TTL:           domainRow.Columns[domainTTLColumn].GetString_(),
+		Status:        append([]string{}, splitList(domainRow.Columns[domainStatusColumn].GetString_())...),
+		PendingUnlock: splitList(domainRow.Columns[domainPendingUnlockColumn].GetString_()),
+		UnlockAt:      unlockTime(domainRow.Columns[domainUnlockRequestedColumn]),
+	}
+	pending, err := getPendingTransfer(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(pending.Columns) != 0 {
+		domain.Status = append(domain.Status, "pendingTransfer")
+		domain.TransferTo = pending.Columns[pendingBuyerColumn].GetString_()
+		domain.TransferAt = formatDate(pending.Columns[pendingCompletesColumn])
+	}
+	return domain, nil
+}
+func (t *DNSChaincode) getOwnedDomains(stub *shim.ChaincodeStub, args []string) (string, error) {
+	userEmail := args[0]
+	check, err := t.checkUserPrivKey(stub,args)
+	if err!= nil {
+		return "",err
+	}
+	if !check {
+		return "", newError(CodeUnauthorized, "User private key can not be verified")
+	}
+	userRow, userErr := stub.GetRow("RegisteredUsers", []shim.Column{{Value: &shim.Column_String_{String_: userEmail}}})
+	if userErr != nil || len(userRow.Columns) == 0 {
+		return "", newError(CodeNotFound, "Error occurred in getting Account. Account Does not exist")
+	} else {
+		return userRow.Columns[4].GetString_(), nil
+	}
+}
+func (t *DNSChaincode) getOwnedBids(stub *shim.ChaincodeStub, args []string) (string, error) {
+	userEmail := args[0]
+	check, err := t.checkUserPrivKey(stub,args)
+	if err!= nil {
+		return "",err
+	}
+	if !check {
+		return "", newError(CodeUnauthorized, "User private key can not be verified")
+	}
+	userRow, userErr := stub.GetRow("RegisteredUsers", []shim.Column{{Value: &shim.Column_String_{String_: userEmail}}})
+	if userErr != nil || len(userRow.Columns) == 0 {
+		return "", newError(CodeNotFound, "Error occurred in getting Account. Account Does not exist")
+	} else {
+		return userRow.Columns[6].GetString_(), nil
+	}
+}
+func (t *DNSChaincode) getTransferRequests(stub *shim.ChaincodeStub, args []string) (string, error) {
+	userEmail := args[0]
+	check, err := t.checkUserPrivKey(stub,args)
+	if err!= nil {
+		return "",err
+	}
+	if !check {
+		return "", newError(CodeUnauthorized, "User private key can not be verified")
+	}
+	userRow, userErr := stub.GetRow("RegisteredUsers", []shim.Column{{Value: &shim.Column_String_{String_: userEmail}}})
+	if userErr != nil || len(userRow.Columns) == 0 {
+		return "", newError(CodeNotFound, "Error occurred in getting Account. Account Does not exist")
+	} else {
+		return userRow.Columns[5].GetString_(), nil
+	}
+}
+
+func (t *DNSChaincode) Query(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+
+	var data interface{}
+	var r_err error
+
+	schema, r_err := lookupSchema(KindQuery, function)
+	if r_err != nil {
+		return nil, r_err
+	}
+	r_err = schema.validate(args)
+	if r_err != nil {
+		return nil, r_err
+	}
+	if schema.Admin {
+		check, err := t.checkUserPrivKey(stub, args)
+		if err != nil {
+			return nil, asChaincodeError(err)
+		}
+		if !check {
+			return nil, newError(CodeUnauthorized, "Signed by wrong private key")
+		}
+		r_err = requireAdministrator(stub, args[0])
+		if r_err != nil {
+			return nil, r_err
+		}
+	}
+
+	if function == "describe" {
+		data = t.describe()
+	} else if function == "getSchemaVersion" {
+		data, r_err = t.getSchemaVersion(stub)
+		if r_err != nil {
+			return nil, asChaincodeError(r_err)
+		}
+	} else if function == "checkIntegrity" {
+		data, r_err = t.checkIntegrity(stub)
+		if r_err != nil {
+			return nil, asChaincodeError(r_err)
+		}
+	} else if function == "query_stats" {
+		data, r_err = t.getStats(stub, args)
+		if r_err != nil {
+			return nil, asChaincodeError(r_err)
+		}
+	} else if function == "checkAccount" {
+		data, r_err = t.checkAccount(stub, args)
+		if r_err != nil {
+			return nil, asChaincodeError(r_err)
+		}
+		if data == false {
+			return nil, newError(CodeUnauthorized, "Signature does not match")
+		}
+	} else if function == "getDomainName" {
+		data, r_err = t.getDomainName(stub, args)
+		if r_err != nil {
+			return nil, asChaincodeError(r_err)
+		}
+	} else if function == "getIPAddress" {
+		data, r_err = t.getIPAddress(stub, args)
+		if r_err != nil {
+			return nil, asChaincodeError(r_err)
+		}
+	} else if function == "getDomain" {
+		data, r_err = t.getDomain(stub, args)
+		if r_err != nil {
+			return nil, asChaincodeError(r_err)
+		}
+	} else if function == "getOwnedDomains" {
+		data, r_err = t.getOwnedDomains(stub, args)
+		if r_err != nil {
+			return nil, asChaincodeError(r_err)
+		}
+	} else if function == "getOwnedBids" {
+		data, r_err = t.getOwnedBids(stub, args)
+		if r_err != nil {
+			return nil, asChaincodeError(r_err)
+		}
+	} else if function == "getTransferRequests" {
+		data, r_err = t.getTransferRequests(stub, args)
+		if r_err != nil {
+			return nil, asChaincodeError(r_err)
+		}
+	} else if function == "getAuction" {
+		data, r_err = t.getAuction(stub, args)
+		if r_err != nil {
+			return nil, asChaincodeError(r_err)
+		}
+	} else if function == "getBalance" {
+		data, r_err = t.getBalance(stub, args)
+		if r_err != nil {
+			return nil, asChaincodeError(r_err)
+		}
+	} else if function == "getNonce" {
+		data, r_err = getAccountNonce(stub, args[0])
+		if r_err != nil {
+			return nil, asChaincodeError(r_err)
+		}
+	} else if function == "getReservations" {
+		data, r_err = t.getReservations(stub)
+		if r_err != nil {
+			return nil, asChaincodeError(r_err)
+		}
+	} else if function == "checkName" {
+		data, r_err = t.checkName(stub, args)
+		if r_err != nil {
+			return nil, asChaincodeError(r_err)
+		}
+	} else if function == "getOrganization" {
+		data, r_err = t.getOrganization(stub, args)
+		if r_err != nil {
+			return nil, asChaincodeError(r_err)
+		}
+	} else if function == "getOrgProposals" {
+		data, r_err = t.getOrgProposals(stub, args)
+		if r_err != nil {
+			return nil, asChaincodeError(r_err)
+		}
+	} else if function == "getOperators" {
+		data, r_err = t.getOperators(stub, args)
+		if r_err != nil {
+			return nil, asChaincodeError(r_err)
+		}
+	} else {
+		fmt.Println("query did not find function: " + function)
+		return nil, newError(CodeInvalidArgument, "Received unknown function query")
+	}
+
+	var converted []byte
+	var converted_err error
+
+	converted, converted_err = json.Marshal(data)
+	if converted_err != nil {
+		return nil, asChaincodeError(converted_err)
+	}
+
+	return converted, nil
+}
+
+func generateRandomNumber() string {
+	//random number generator
+	const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	const (
+		letterIdxBits = 6                    // 6 bits to represent a letter index
+		letterIdxMask = 1<<letterIdxBits - 1 // All 1-bits, as many as letterIdxBits
+		letterIdxMax  = 73 / letterIdxBits   // # of letter indices fitting in 63 bits
+	)
+	n := 6
+	var src = rand.NewSource(time.Now().UnixNano())
+	b := make([]byte, n)
+	// A src.Int63() generates 63 random bits, enough for letterIdxMax characters!
+	for i, cache, remain := n-1, src.Int63(), letterIdxMax; i >= 0; {
+		if remain == 0 {
+			cache, remain = src.Int63(), letterIdxMax
+		}
+		if idx := int(cache & letterIdxMask); idx < len(letterBytes) {
+			b[i] = letterBytes[idx]
+			i--
+		}
+		cache >>= letterIdxBits
+		remain--
+	}
+	return string(b)
+}
+
+func (t *DNSChaincode) getUniqueID(stub *shim.ChaincodeStub, i int) (string, error) {
+	randomID := generateRandomNumber()
+	requestRow, err := stub.GetRow("TransferRequests", []shim.Column{{Value: &shim.Column_String_{String_: randomID}}})
+	if (err != nil) {
+		return "", err
+	} else if len(requestRow.Columns) == 0 {
+		return randomID, nil
+	} else if i <= 1 {
+		return "", newError(CodeInternal, "Cannot generate unique random ID for the transection after 10 attempts. Try again.")
+	}
+	return t.getUniqueID(stub, i-1)
+}
+func (t *DNSChaincode) placeBid(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	fromBid := args[0]
+	toBid := args[2]
+	domainName := args[3]
+	amount, _ := strconv.ParseUint(args[4], 10, 64)
+
+	// Check both accounts and the domain before anything is written
+	_, err := getAccountRow(stub, toBid)
+	if err != nil {
+		return nil, err
+	}
+	_, err = getAccountRow(stub, fromBid)
+	if err != nil {
+		return nil, err
+	}
+	domainRow, err := stub.GetRow("NameToIP", []shim.Column{{Value: &shim.Column_String_{String_: domainName}}})
+	if err != nil || len(domainRow.Columns) == 0 {
+		return nil, newError(CodeNotFound, "Domain %s is not registered", domainName)
+	}
+	if domainRow.Columns[domainOwnerColumn].GetString_() != toBid {
+		return nil, newError(CodeConflict, "%s does not own %s", toBid, domainName)
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	transectionID, err := t.getUniqueID(stub, 10) //try 10 times to generate a random ID
+	if err!=nil {
+		return nil, err
+	}
+	row, err := stub.GetRow("TransferRequests", []shim.Column{{Value: &shim.Column_String_{String_: transectionID}}})
+	if err != nil || len(row.Columns) == 0 {
+		rowAdded, rowErr := stub.InsertRow("TransferRequests", shim.Row{
+			Columns: []*shim.Column{
+				&shim.Column{Value: &shim.Column_String_{String_: transectionID}},
+				&shim.Column{Value: &shim.Column_String_{String_: toBid}},
+				&shim.Column{Value: &shim.Column_String_{String_: fromBid}},
+				amountColumn(amount),
+				&shim.Column{Value: &shim.Column_String_{String_: bidStatusOpen}},
+				dateColumn(now),
+				dateColumn(time.Time{}),
+				&shim.Column{Value: &shim.Column_String_{String_: domainName}},
+			},
+		})
+
+		if rowErr != nil || !rowAdded {
+			return nil, newError(CodeInternal, "Error creating row: %s", rowErr)
+		}
+	} else {
+		return nil, newError(CodeConflict, "Something went wrong. TransectionID already exist. Cannot edit existing ID. Please place bid again.")
+	}
+
+	err = addStat(stub, statsKindTotal, statsBidsOpen, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	//Update accounts of owner and potential buyer
+	accountRow, accountErr := stub.GetRow("RegisteredUsers", []shim.Column{{Value: &shim.Column_String_{String_: toBid}}})	
+	if accountErr != nil {
+		return nil, accountErr	
+	} else if len(accountRow.Columns) == 0 {
+		return nil, newError(CodeNotFound, "Account does not exists. Not sure how did you get this far but its time to go back and register.")	
+	} else {
+		_, err = stub.ReplaceRow("RegisteredUsers", shim.Row{
+			Columns: []*shim.Column{
+				{Value: &shim.Column_String_{String_: accountRow.Columns[0].GetString_()}},
+				{Value: &shim.Column_String_{String_: accountRow.Columns[1].GetString_()}},
+				{Value: &shim.Column_String_{String_: accountRow.Columns[2].GetString_()}},
+				accountRow.Columns[accountRegistrationDateColumn],
+				{Value: &shim.Column_String_{String_: accountRow.Columns[4].GetString_()}},
+				{Value: &shim.Column_String_{String_: strings.Join([]string{accountRow.Columns[5].GetString_(),transectionID},",")}},
+				{Value: &shim.Column_String_{String_: accountRow.Columns[6].GetString_()}},
+			},
+		})
+
+		if err != nil {
+			return nil, newError(CodeInternal, "Error updating row for the profile: %s", err)
+		}
+	}
+
+	accountRow, accountErr = stub.GetRow("RegisteredUsers", []shim.Column{{Value: &shim.Column_String_{String_: fromBid}}})
+	if accountErr != nil {
+		return nil, accountErr	
+	} else if len(accountRow.Columns) == 0 {
+		return nil, newError(CodeNotFound, "Account does not exists. Not sure how did you get this far but its time to go back and register.")	
+	} else {
+		_, err = stub.ReplaceRow("RegisteredUsers", shim.Row{
+			Columns: []*shim.Column{
+				{Value: &shim.Column_String_{String_: accountRow.Columns[0].GetString_()}},
+				{Value: &shim.Column_String_{String_: accountRow.Columns[1].GetString_()}},
+				{Value: &shim.Column_String_{String_: accountRow.Columns[2].GetString_()}},
+				accountRow.Columns[accountRegistrationDateColumn],
+				{Value: &shim.Column_String_{String_: accountRow.Columns[4].GetString_()}},
+				{Value: &shim.Column_String_{String_: accountRow.Columns[5].GetString_()}},
+				{Value: &shim.Column_String_{String_: strings.Join([]string{accountRow.Columns[6].GetString_(),transectionID},",")}},
+			},
+		})
+
+		if err != nil {
+			return nil, newError(CodeInternal, "Error updating row for the profile: %s", err)
+		}
+	}
+
+	return nil, nil
+}
+func (t *DNSChaincode) createAccount(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+
+	//args[0] = emailID
+	//args[2] = public key
+	//args[4] = key algorithm, optional
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	acc := account{email: args[0], registrationDate: now, pubKey: args[2], password: args[3]} 
+	pubByte, _ := decodePublicKey(acc.pubKey)
+	rawKey, err := parsePKIXPublicKey(pubByte)
+	if err != nil {
+		return nil, err
+	}
+	algorithm := defaultKeyAlgorithm(rawKey)
+	if len(args) > 4 {
+		algorithm = args[4]
+	}
+	_, err = t.newUnsignerFromKey(rawKey, algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	accountRow, err := stub.GetRow("RegisteredUsers", []shim.Column{{Value: &shim.Column_String_{String_: acc.email}}})
+	if err != nil || len(accountRow.Columns) == 0 {
+		rowAdded, rowErr := stub.InsertRow("RegisteredUsers", shim.Row{
+			Columns: []*shim.Column{
+				&shim.Column{Value: &shim.Column_String_{String_: acc.email}},
+				&shim.Column{Value: &shim.Column_String_{String_: acc.pubKey}},
+				&shim.Column{Value: &shim.Column_String_{String_: acc.password}},
+				dateColumn(acc.registrationDate),
+				&shim.Column{Value: &shim.Column_String_{String_: ""}},
+				&shim.Column{Value: &shim.Column_String_{String_: ""}},
+				&shim.Column{Value: &shim.Column_String_{String_: ""}},
+			},
+		})
+
+		if rowErr != nil || !rowAdded {
+			return nil, newError(CodeInternal, "Error creating row: %s", rowErr)
+		}
+	} else {
+		return nil, newError(CodeConflict, "Account already exists. Please login.")
+	}
+
+	err = setKeyAlgorithm(stub, acc.email, algorithm)
+	if err != nil {
+		return nil, err
+	}
+	err = addStat(stub, statsKindTotal, statsAccounts, 1)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+func (t *DNSChaincode) registerDomain(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	
+	userEmail := args[0]
+	domainName := args[2]
+	ipAddress := args[3]
+	duration, _ := strconv.ParseUint(args[4], 10, 64)
+	ttl := defaultTTL
+	if len(args) > 5 {
+		ttl = args[5]
+	}
+	token := ""
+	if len(args) > 6 {
+		token = args[6]
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	won, err := claimAuctionedName(stub, domainName, userEmail, now)
+	if err != nil {
+		return nil, err
+	}
+	err = t.checkRegistrable(stub, domainName, userEmail, token, won)
+	if err != nil {
+		return nil, err
+	}
+
+	//Update Name to IP lookup table as well as IP to Name. 
+	domainRow, err := stub.GetRow("NameToIP", []shim.Column{{Value: &shim.Column_String_{String_: domainName}}})
+	if err != nil || len(domainRow.Columns) == 0 {
+		rowAdded, rowErr := stub.InsertRow("NameToIP", shim.Row{
+			Columns: []*shim.Column{
+				&shim.Column{Value: &shim.Column_String_{String_: domainName}},
+				&shim.Column{Value: &shim.Column_String_{String_: ipAddress}},
+				&shim.Column{Value: &shim.Column_String_{String_: userEmail}},
+				dateColumn(now),
+				daysColumn(duration),
+				dateColumn(expiryTime(now, duration)),
+				&shim.Column{Value: &shim.Column_String_{String_: ttl}},
+				&shim.Column{Value: &shim.Column_String_{String_: ""}},
+				&shim.Column{Value: &shim.Column_String_{String_: ""}},
+				dateColumn(time.Time{}),
+			},
+		})
+
+		if rowErr != nil || !rowAdded {
+			return nil, newError(CodeInternal, "Error creating row: %s", rowErr)
+		}
+	} else {
+		return nil, newError(CodeConflict, "Domain already exists. Please request a transfer.")
+	}
+
+	ipRow, ipErr := stub.GetRow("IPToName", []shim.Column{{Value: &shim.Column_String_{String_: ipAddress}}})
+	if ipErr != nil || len(ipRow.Columns) == 0 {
+		rowAdded, rowErr := stub.InsertRow("IPToName", shim.Row{
+			Columns: []*shim.Column{
+				{Value: &shim.Column_String_{String_: ipAddress}},
+				{Value: &shim.Column_String_{String_: domainName}},
+				{Value: &shim.Column_String_{String_: userEmail}},
+				dateColumn(now),
+				daysColumn(duration),
+			},
+		})
+
+		if rowErr != nil || !rowAdded {
+			return nil, newError(CodeInternal, "Error creating row: %s", rowErr)
+		}
+	} else {
+		return nil, newError(CodeConflict, "IP address is already assigned to another domain name. Please select a new IP address.")
+	}
+
+	err = recordRegistrationStats(stub, now, duration)
+	if err != nil {
+		return nil, err
+	}
+	err = indexSkeleton(stub, domainName)
+	if err != nil {
+		return nil, err
+	}
+
+	accountRow, accountErr := stub.GetRow("RegisteredUsers", []shim.Column{{Value: &shim.Column_String_{String_: userEmail}}})
+	
+	if accountErr != nil {
+		return nil, accountErr
+	} else if len(accountRow.Columns) == 0 {
+		return nil, newError(CodeNotFound, "Account does not exists. Not sure how did you get this far but its time to go back and register.")	
+	} else {
+		_, err = stub.ReplaceRow("RegisteredUsers", shim.Row{
+			Columns: []*shim.Column{
+				{Value: &shim.Column_String_{String_: accountRow.Columns[0].GetString_()}},
+				{Value: &shim.Column_String_{String_: accountRow.Columns[1].GetString_()}},
+				{Value: &shim.Column_String_{String_: accountRow.Columns[2].GetString_()}},
+				accountRow.Columns[accountRegistrationDateColumn],
+				{Value: &shim.Column_String_{String_: strings.Join([]string{accountRow.Columns[4].GetString_(),domainName},",")}},
+				{Value: &shim.Column_String_{String_: accountRow.Columns[5].GetString_()}},
+				{Value: &shim.Column_String_{String_: accountRow.Columns[6].GetString_()}},
+			},
+		})
+
+		if err != nil {
+			return nil, newError(CodeInternal, "Error updating row for the profile: %s", err)
+		}
+	}
+
+	return nil, nil
+}
+// getRequestID returns the open transfer request owner received from buyer
+// for domainName
+func (t *DNSChaincode) getRequestID(stub *shim.ChaincodeStub, owner string, domainName string, buyer string) (string, error) {
+	rowChan, err := stub.GetRowsByIndex("TransferRequests", "Owner", shim.Column{Value: &shim.Column_String_{String_: owner}})
+	if err!=nil {
+		return "", err
+	}
+	requestID := ""
+	for chanValue := range rowChan {
+		if chanValue.Columns[2].GetString_() == buyer &&
+			chanValue.Columns[7].GetString_() == domainName && chanValue.Columns[4].GetString_() == bidStatusOpen {
+			requestID = chanValue.Columns[0].GetString_()
+		}
+	}
+	if requestID == "" {
+		return "", newError(CodeNotFound, "Could not find request ID. Please check if transfer request is submitted or not")
+	}
+	return requestID, nil
+}
+
+// removeFromList drops every occurrence of item from a comma separated list
+// column
+func removeFromList(list string, item string) string {
+	var kept []string
+	for _, entry := range splitList(list) {
+		if entry != item {
+			kept = append(kept, entry)
+		}
+	}
+	return strings.Join(kept, ",")
+}
+
+// transferDomain accepts the open bid newOwner placed on a domain and hands
+// the domain over, pointing it at newIPAddress. The lookup tables and the
+// ownership lists of both accounts are updated together. If the registry has
+// a transfer window, the transfer is only started here and completes when
+// the window closes, see transfers.go.
+//
+// args: owner, signature, domainName, newOwner, newIPAddress
+func (t *DNSChaincode) transferDomain(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	
+	oldOwner := args[0]
+	domainName := args[2]
+	newOwner := args[3]
+	newIP := args[4]
+
+	nameRow, nameErr := stub.GetRow("NameToIP", []shim.Column{{Value: &shim.Column_String_{String_: domainName}}})
+	if nameErr != nil || len(nameRow.Columns) == 0 {
+		return nil, newError(CodeNotFound, "Domain does not exists. Not sure how did you get this far but its time to go back and register.")	
+	}
+	if nameRow.Columns[domainOwnerColumn].GetString_() != oldOwner {
+		return nil, newError(CodeUnauthorized, "%s does not own %s", oldOwner, domainName)
+	}
+	err := checkDomainStatus(nameRow, StatusTransferProhibited)
+	if err != nil {
+		return nil, err
+	}
+	err = checkNoPendingTransfer(stub, domainName)
+	if err != nil {
+		return nil, err
+	}
+
+	requestID, err := t.getRequestID(stub, oldOwner, domainName, newOwner)
+	if err!= nil {
+		return nil, err
+	}
+	nameRow, transferRow, err := checkTransfer(stub, domainName, requestID, newIP)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	window, err := getTransferWindow(stub)
+	if err != nil {
+		return nil, err
+	}
+	if window > 0 {
+		return nil, startPendingTransfer(stub, nameRow, transferRow, newIP, now, window)
+	}
+	err = applyTransfer(stub, nameRow, transferRow, newIP, now)
+	if err != nil {
+		return nil, err
+	}
+	emitEvent(stub, RegistryEvent{Type: EventTransferCompleted, Domain: domainName, Owner: oldOwner, Buyer: newOwner, At: now.Format(timeFormat)})
+	return nil, nil
+}
+
+// checkTransfer makes sure the transfer request can be carried out, without
+// writing anything: the request is open and still made to the owner of the
+// domain, both accounts exist and the new address is free.
+func checkTransfer(stub *shim.ChaincodeStub, domainName string, requestID string, newIP string) (shim.Row, shim.Row, error) {
+	var transferRow shim.Row
+	nameRow, err := stub.GetRow("NameToIP", []shim.Column{{Value: &shim.Column_String_{String_: domainName}}})
+	if err != nil || len(nameRow.Columns) == 0 {
+		return nameRow, transferRow, newError(CodeNotFound, "Domain %s is not registered", domainName)
+	}
+	transferRow, err = stub.GetRow("TransferRequests", []shim.Column{{Value: &shim.Column_String_{String_: requestID}}})
+	if err != nil || len(transferRow.Columns) == 0 {
+		return nameRow, transferRow, newError(CodeNotFound, "Transfer ID does not exists. Please check transfer request.")
+	}
+	if transferRow.Columns[4].GetString_() != bidStatusOpen || transferRow.Columns[7].GetString_() != domainName ||
+		transferRow.Columns[1].GetString_() != nameRow.Columns[domainOwnerColumn].GetString_() {
+		return nameRow, transferRow, newError(CodeConflict, "Transfer request %s is no longer open for %s", requestID, domainName)
+	}
+	for _, account := range []string{transferRow.Columns[1].GetString_(), transferRow.Columns[2].GetString_()} {
+		_, err = getAccountRow(stub, account)
+		if err != nil {
+			return nameRow, transferRow, err
+		}
+	}
+	if newIP != nameRow.Columns[domainIPColumn].GetString_() {
+		ipRow, ipErr := stub.GetRow("IPToName", []shim.Column{{Value: &shim.Column_String_{String_: newIP}}})
+		if ipErr == nil && len(ipRow.Columns) != 0 {
+			return nameRow, transferRow, newError(CodeConflict, "IP address is already assigned to another domain name. Please select a new IP address.")
+		}
+	}
+	return nameRow, transferRow, nil
+}
+
+// applyTransfer carries out a transfer request checked by checkTransfer
+func applyTransfer(stub *shim.ChaincodeStub, nameRow shim.Row, transferRow shim.Row, newIP string, when time.Time) error {
+	domainName := nameRow.Columns[domainNameColumn].GetString_()
+	oldOwner := transferRow.Columns[1].GetString_()
+	newOwner := transferRow.Columns[2].GetString_()
+	requestID := transferRow.Columns[0].GetString_()
+
+	_, err := stub.ReplaceRow("TransferRequests", shim.Row{
+		Columns: []*shim.Column{
+			transferRow.Columns[0],
+			transferRow.Columns[1],
+			transferRow.Columns[2],
+			transferRow.Columns[3],
+			{Value: &shim.Column_String_{String_: bidStatusAccepted}},
+			transferRow.Columns[5],
+			dateColumn(when),
+			{Value: &shim.Column_String_{String_: domainName}},
+		},
+	})
+	if err != nil {
+		return newError(CodeInternal, "Error updating row: %s", err)
+	}
+	err = moveBidStat(stub, transferRow.Columns[4].GetString_(), bidStatusAccepted)
+	if err != nil {
+		return err
+	}
+
+	// The domain and the request leave the owner's lists, the buyer gains
+	// the domain and loses the bid
+	accountRow, err := getAccountRow(stub, oldOwner)
+	if err != nil {
+		return err
+	}
+	err = replaceAccountColumns(stub, accountRow, map[int]string{
+		accountDomainsColumn:       removeFromList(accountRow.Columns[accountDomainsColumn].GetString_(), domainName),
+		accountRequestedBidsColumn: removeFromList(accountRow.Columns[accountRequestedBidsColumn].GetString_(), requestID),
+	})
+	if err != nil {
+		return err
+	}
+
+	accountRow, err = getAccountRow(stub, newOwner)
+	if err != nil {
+		return err
+	}
+	err = replaceAccountColumns(stub, accountRow, map[int]string{
+		accountDomainsColumn:   strings.Join([]string{accountRow.Columns[accountDomainsColumn].GetString_(), domainName}, ","),
+		accountOwnedBidsColumn: removeFromList(accountRow.Columns[accountOwnedBidsColumn].GetString_(), requestID),
+	})
+	if err != nil {
+		return err
+	}
+
+	//Add new IP and domain to IP and domain Table
+
+	oldIP := nameRow.Columns[domainIPColumn].GetString_()
+	duration := nameRow.Columns[domainDurationColumn].GetUint64()
+	// The new owner starts without any lock
+	err = replaceDomainColumns(stub, nameRow, map[int]*shim.Column{
+		domainIPColumn:              stringColumn(newIP),
+		domainOwnerColumn:           stringColumn(newOwner),
+		domainRegisteredColumn:      dateColumn(when),
+		domainExpiryColumn:          dateColumn(expiryTime(when, duration)),
+		domainStatusColumn:          stringColumn(""),
+		domainPendingUnlockColumn:   stringColumn(""),
+		domainUnlockRequestedColumn: dateColumn(time.Time{}),
+	})
+	if err != nil {
+		return err
+	}
+	err = clearOperators(stub, domainName)
+	if err != nil {
+		return err
+	}
+	// The registration date restarts on transfer, so does the expiry
+	err = moveExpiryStat(stub, nameRow, when)
+	if err != nil {
+		return err
+	}
+
+	// IPToName is keyed by the address, so the old entry goes and a new one
+	// is created even if the address did not change
+	err = stub.DeleteRow("IPToName", []shim.Column{{Value: &shim.Column_String_{String_: oldIP}}})
+	if err != nil {
+		return newError(CodeInternal, "Error deleting row: %s", err)
+	}
+	rowAdded, rowErr := stub.InsertRow("IPToName", shim.Row{
+		Columns: []*shim.Column{
+			{Value: &shim.Column_String_{String_: newIP}},
+			{Value: &shim.Column_String_{String_: domainName}},
+			{Value: &shim.Column_String_{String_: newOwner}},
+			dateColumn(when),
+			daysColumn(duration),
+		},
+	})
+	if rowErr != nil || !rowAdded {
+		return newError(CodeInternal, "Error creating row: %s", rowErr)
+	}
+
+	return nil
+}
@@ -0,0 +1,273 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// The registry statistics are kept as counters in the RegistryStats table.
+// Every invoke that changes something we count updates the matching counter
+// in the same transaction, so query_stats never has to walk the domain,
+// account or transfer request tables.
+//
+// RegistryStats is keyed by (Kind, Bucket). Totals live under the "total"
+// kind, per-day counters use the day (statsDayFormat) as their bucket.
+const statsTable = "RegistryStats"
+
+const (
+	statsKindTotal         = "total"
+	statsKindRegistrations = "registrations"
+	statsKindExpiring      = "expiring"
+
+	statsDomains      = "domains"
+	statsAccounts     = "accounts"
+	statsBidsOpen     = "bids:open"
+	statsBidsAccepted = "bids:accepted"
+	statsBidsRejected = "bids:rejected"
+
+	statsDayFormat = "2006-01-02"
+
+	// defaultExpiryWindow is the number of days query_stats looks ahead for
+	// expiring domains when the caller does not ask for a window.
+	defaultExpiryWindow = 30
+)
+
+// bid statuses as stored in the TransferRequests table
+const (
+	bidStatusOpen     = "open"
+	bidStatusAccepted = "transfer accepted"
+	bidStatusRejected = "transfer rejected"
+)
+
+// RegistryStats is the payload returned by query_stats
+type RegistryStats struct {
+	Domains             int64            `json:"domains"`
+	Accounts            int64            `json:"accounts"`
+	OpenBids            int64            `json:"openBids"`
+	AcceptedBids        int64            `json:"acceptedBids"`
+	RejectedBids        int64            `json:"rejectedBids"`
+	ExpiryWindowDays    int              `json:"expiryWindowDays"`
+	ExpiringDomains     int64            `json:"expiringDomains"`
+	RegistrationsPerDay map[string]int64 `json:"registrationsPerDay"`
+}
+
+func createStatsTable(stub *shim.ChaincodeStub) error {
+	return stub.CreateTable(statsTable, []*shim.ColumnDefinition{
+		{"Kind", shim.ColumnDefinition_STRING, true, false},
+		{"Bucket", shim.ColumnDefinition_STRING, true, false},
+		{"Count", shim.ColumnDefinition_INT64, false, false},
+	})
+}
+
+func statsKey(kind string, bucket string) []shim.Column {
+	return []shim.Column{
+		{Value: &shim.Column_String_{String_: kind}},
+		{Value: &shim.Column_String_{String_: bucket}},
+	}
+}
+
+// getStat returns the current value of a counter, 0 if it was never set
+func getStat(stub TableReader, kind string, bucket string) (int64, error) {
+	row, err := stub.GetRow(statsTable, statsKey(kind, bucket))
+	if err != nil {
+		return 0, err
+	}
+	if len(row.Columns) == 0 {
+		return 0, nil
+	}
+	return row.Columns[2].GetInt64(), nil
+}
+
+// addStat adds delta to a counter, creating it if needed
+func addStat(stub *shim.ChaincodeStub, kind string, bucket string, delta int64) error {
+	if delta == 0 {
+		return nil
+	}
+	existing, err := stub.GetRow(statsTable, statsKey(kind, bucket))
+	if err != nil {
+		return newError(CodeInternal, "Error reading counter %s/%s: %s", kind, bucket, err)
+	}
+	var current int64
+	if len(existing.Columns) != 0 {
+		current = existing.Columns[2].GetInt64()
+	}
+	row := shim.Row{
+		Columns: []*shim.Column{
+			{Value: &shim.Column_String_{String_: kind}},
+			{Value: &shim.Column_String_{String_: bucket}},
+			{Value: &shim.Column_Int64{Int64: current + delta}},
+		},
+	}
+	if len(existing.Columns) == 0 {
+		_, err = stub.InsertRow(statsTable, row)
+	} else {
+		_, err = stub.ReplaceRowIfVersion(statsTable, row, existing.Version)
+	}
+	if err != nil {
+		return newError(CodeInternal, "Error updating counter %s/%s: %s", kind, bucket, err)
+	}
+	return nil
+}
+
+// bidStatCounter maps a bid status to its counter
+func bidStatCounter(status string) string {
+	switch status {
+	case bidStatusOpen:
+		return statsBidsOpen
+	case bidStatusAccepted:
+		return statsBidsAccepted
+	case bidStatusRejected:
+		return statsBidsRejected
+	}
+	return ""
+}
+
+// moveBidStat records a transfer request moving from one status to another
+func moveBidStat(stub *shim.ChaincodeStub, from string, to string) error {
+	if from == to {
+		return nil
+	}
+	if counter := bidStatCounter(from); counter != "" {
+		if err := addStat(stub, statsKindTotal, counter, -1); err != nil {
+			return err
+		}
+	}
+	if counter := bidStatCounter(to); counter != "" {
+		if err := addStat(stub, statsKindTotal, counter, 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expiryTime returns when a registration made at registered for days runs
+// out
+func expiryTime(registered time.Time, days uint64) time.Time {
+	return registered.AddDate(0, 0, int(days))
+}
+
+// expiryColumn returns the Expiry column of a registration made at the date
+// in registered for days, no date if registered holds none
+func expiryColumn(registered *shim.Column, days uint64) *shim.Column {
+	date, ok := columnDate(registered)
+	if !ok {
+		return dateColumn(time.Time{})
+	}
+	return dateColumn(expiryTime(date, days))
+}
+
+// expiryDay returns the day the registration of a NameToIP row runs out. ok
+// is false if the row has no registration date.
+func expiryDay(row shim.Row) (string, bool) {
+	registered, ok := columnDate(row.Columns[domainRegisteredColumn])
+	if !ok {
+		return "", false
+	}
+	return expiryTime(registered, row.Columns[domainDurationColumn].GetUint64()).Format(statsDayFormat), true
+}
+
+// recordRegistrationStats counts a newly registered domain
+func recordRegistrationStats(stub *shim.ChaincodeStub, registered time.Time, days uint64) error {
+	if err := addStat(stub, statsKindTotal, statsDomains, 1); err != nil {
+		return err
+	}
+	if err := addStat(stub, statsKindRegistrations, registered.Format(statsDayFormat), 1); err != nil {
+		return err
+	}
+	return addStat(stub, statsKindExpiring, expiryTime(registered, days).Format(statsDayFormat), 1)
+}
+
+// moveExpiryStat moves a domain from the expiry day of its NameToIP row to
+// the one of a registration restarted at newRegistered
+func moveExpiryStat(stub *shim.ChaincodeStub, row shim.Row, newRegistered time.Time) error {
+	oldDay, oldOk := expiryDay(row)
+	newDay := expiryTime(newRegistered, row.Columns[domainDurationColumn].GetUint64()).Format(statsDayFormat)
+	if oldOk && oldDay == newDay {
+		return nil
+	}
+	if oldOk {
+		if err := addStat(stub, statsKindExpiring, oldDay, -1); err != nil {
+			return err
+		}
+	}
+	return addStat(stub, statsKindExpiring, newDay, 1)
+}
+
+// getStats returns the registry counters. args[0], if given, is the number of
+// days to look ahead for expiring domains.
+func (t *DNSChaincode) getStats(stub *shim.ChaincodeStub, args []string) (*RegistryStats, error) {
+	window := defaultExpiryWindow
+	if len(args) > 0 {
+		var err error
+		window, err = strconv.Atoi(args[0])
+		if err != nil || window < 0 {
+			return nil, newError(CodeInvalidArgument, "Expiry window must be a non-negative number of days")
+		}
+	}
+
+	stats := &RegistryStats{ExpiryWindowDays: window, RegistrationsPerDay: make(map[string]int64)}
+	totals := []struct {
+		name  string
+		value *int64
+	}{
+		{statsDomains, &stats.Domains},
+		{statsAccounts, &stats.Accounts},
+		{statsBidsOpen, &stats.OpenBids},
+		{statsBidsAccepted, &stats.AcceptedBids},
+		{statsBidsRejected, &stats.RejectedBids},
+	}
+	for _, total := range totals {
+		value, err := getStat(stub, statsKindTotal, total.name)
+		if err != nil {
+			return nil, err
+		}
+		*total.value = value
+	}
+
+	rowChan, err := stub.GetRows(statsTable, []shim.Column{{Value: &shim.Column_String_{String_: statsKindRegistrations}}})
+	if err != nil {
+		return nil, err
+	}
+	for row := range rowChan {
+		stats.RegistrationsPerDay[row.Columns[1].GetString_()] = row.Columns[2].GetInt64()
+	}
+
+	// Buckets use an ISO day so they compare correctly as strings
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	today := now.Format(statsDayFormat)
+	last := now.AddDate(0, 0, window).Format(statsDayFormat)
+	rowChan, err = stub.GetRows(statsTable, []shim.Column{{Value: &shim.Column_String_{String_: statsKindExpiring}}})
+	if err != nil {
+		return nil, err
+	}
+	for row := range rowChan {
+		day := row.Columns[1].GetString_()
+		if day < today || day > last {
+			continue
+		}
+		stats.ExpiringDomains += row.Columns[2].GetInt64()
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,358 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Registries that deal in high-value names can set a transfer window with
+// setTransferWindow. transferDomain then only starts a transfer: it is
+// recorded in the PendingTransfers table, the domain cannot be updated,
+// deleted or transferred again, and the owner can still back out with
+// cancelTransfer. The first invoke of any kind after the window closes
+// completes it. Times are taken from the transaction timestamp, so every
+// peer agrees on whether a window has closed.
+//
+// Besides its row, every pending transfer has a state key made of
+// pendingDuePrefix, its completion time and the domain, see pendingDueKey.
+// The keys sort by completion time, so finalizeTransfers only range-scans
+// the transfers that are due instead of reading the whole table on every
+// invoke.
+//
+// A window of 0 days, the default, transfers at once.
+const pendingTransfersTable = "PendingTransfers"
+
+// pendingDuePrefix starts the state keys indexing pending transfers by
+// completion time. Table keys start with a digit, so it cannot collide.
+const pendingDuePrefix = "pendingTransferDue:"
+
+// transferWindowKey is the state key of the transfer window, in days
+const transferWindowKey = "transferWindowDays"
+
+// columns of the PendingTransfers table
+const (
+	pendingDomainColumn = iota
+	pendingRequestColumn
+	pendingBuyerColumn
+	pendingIPColumn
+	pendingStartedColumn
+	pendingCompletesColumn
+)
+
+func createPendingTransfersTable(stub *shim.ChaincodeStub) error {
+	return stub.CreateTable(pendingTransfersTable, []*shim.ColumnDefinition{
+		{"domainName", shim.ColumnDefinition_STRING, true, false},
+		{"RequestID", shim.ColumnDefinition_STRING, false, false},
+		{"Buyer", shim.ColumnDefinition_STRING, false, false},
+		{"NewIP", shim.ColumnDefinition_STRING, false, false},
+		{"Started", shim.ColumnDefinition_INT64, false, false},
+		{"CompletesAt", shim.ColumnDefinition_INT64, false, false},
+	})
+}
+
+// txTime returns the timestamp of the transaction
+func txTime(stub *shim.ChaincodeStub) (time.Time, error) {
+	timestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, newError(CodeInternal, "Error reading transaction timestamp: %s", err)
+	}
+	if timestamp == nil {
+		return time.Time{}, newError(CodeInternal, "Transaction has no timestamp")
+	}
+	return time.Unix(timestamp.Seconds, int64(timestamp.Nanos)).UTC(), nil
+}
+
+func getTransferWindow(stub TableReader) (int, error) {
+	value, err := stub.GetState(transferWindowKey)
+	if err != nil {
+		return 0, newError(CodeInternal, "Error reading transfer window: %s", err)
+	}
+	if value == nil {
+		return 0, nil
+	}
+	days, err := strconv.Atoi(string(value))
+	if err != nil {
+		return 0, newError(CodeInternal, "Invalid transfer window %q", value)
+	}
+	return days, nil
+}
+
+// pendingDueKey is the state key indexing the pending transfer of domainName.
+// The completion time is zero-padded, so keys sort in time order.
+func pendingDueKey(completesAt time.Time, domainName string) string {
+	return fmt.Sprintf("%s%020d:%s", pendingDuePrefix, completesAt.UnixNano(), domainName)
+}
+
+// pendingCompletesAt returns when a pending transfer completes. A transfer
+// without a completion time is due at once.
+func pendingCompletesAt(row shim.Row) time.Time {
+	completesAt, ok := columnDate(row.Columns[pendingCompletesColumn])
+	if !ok {
+		return time.Unix(0, 0)
+	}
+	return completesAt
+}
+
+// indexPendingTransfer creates the due key of a pending transfer row
+func indexPendingTransfer(stub *shim.ChaincodeStub, row shim.Row) error {
+	domainName := row.Columns[pendingDomainColumn].GetString_()
+	err := stub.PutState(pendingDueKey(pendingCompletesAt(row), domainName), []byte(domainName))
+	if err != nil {
+		return newError(CodeInternal, "Error indexing pending transfer: %s", err)
+	}
+	return nil
+}
+
+// deletePendingTransfer deletes a pending transfer row and its due key
+func deletePendingTransfer(stub *shim.ChaincodeStub, row shim.Row) error {
+	domainName := row.Columns[pendingDomainColumn].GetString_()
+	err := stub.DelState(pendingDueKey(pendingCompletesAt(row), domainName))
+	if err != nil {
+		return newError(CodeInternal, "Error deleting pending transfer index: %s", err)
+	}
+	err = stub.DeleteRow(pendingTransfersTable, stringKey(domainName))
+	if err != nil {
+		return newError(CodeInternal, "Error deleting row: %s", err)
+	}
+	return nil
+}
+
+func getPendingTransfer(stub TableReader, domainName string) (shim.Row, error) {
+	row, err := stub.GetRow(pendingTransfersTable, stringKey(domainName))
+	if err != nil {
+		return row, newError(CodeInternal, "Error reading pending transfers: %s", err)
+	}
+	return row, nil
+}
+
+// checkNoPendingTransfer fails while a transfer of the domain is pending
+func checkNoPendingTransfer(stub *shim.ChaincodeStub, domainName string) error {
+	row, err := getPendingTransfer(stub, domainName)
+	if err != nil {
+		return err
+	}
+	if len(row.Columns) != 0 {
+		return newError(CodeConflict, "A transfer of %s to %s is pending until %s", domainName,
+			row.Columns[pendingBuyerColumn].GetString_(), formatDate(row.Columns[pendingCompletesColumn]))
+	}
+	return nil
+}
+
+// startPendingTransfer records a transfer request checked by checkTransfer,
+// to be completed once the window has passed
+func startPendingTransfer(stub *shim.ChaincodeStub, nameRow shim.Row, transferRow shim.Row, newIP string, now time.Time, windowDays int) error {
+	domainName := nameRow.Columns[domainNameColumn].GetString_()
+	completesAt := now.AddDate(0, 0, windowDays)
+	row := shim.Row{
+		Columns: []*shim.Column{
+			{Value: &shim.Column_String_{String_: domainName}},
+			{Value: &shim.Column_String_{String_: transferRow.Columns[0].GetString_()}},
+			{Value: &shim.Column_String_{String_: transferRow.Columns[2].GetString_()}},
+			{Value: &shim.Column_String_{String_: newIP}},
+			dateColumn(now),
+			dateColumn(completesAt),
+		},
+	}
+	_, err := stub.InsertRow(pendingTransfersTable, row)
+	if err != nil {
+		return newError(CodeInternal, "Error creating row: %s", err)
+	}
+	err = indexPendingTransfer(stub, row)
+	if err != nil {
+		return err
+	}
+	emitEvent(stub, RegistryEvent{
+		Type:   EventTransferPending,
+		Domain: domainName,
+		Owner:  transferRow.Columns[1].GetString_(),
+		Buyer:  transferRow.Columns[2].GetString_(),
+		At:     completesAt.Format(timeFormat),
+	})
+	return nil
+}
+
+// finalizeTransfers completes every pending transfer whose window has
+// closed. A transfer that can no longer be carried out, e.g. because its new
+// address has been taken in the meantime, is dropped and reported with a
+// transferFailed event; the invoke itself goes ahead.
+func finalizeTransfers(stub *shim.ChaincodeStub) error {
+	now, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	due, err := dueTransfers(stub, now)
+	if err != nil {
+		return err
+	}
+	for _, key := range due {
+		domainName := key[len(pendingDuePrefix)+21:]
+		row, err := getPendingTransfer(stub, domainName)
+		if err != nil {
+			return err
+		}
+		if len(row.Columns) == 0 {
+			// the index outlived its row
+			err = stub.DelState(key)
+			if err != nil {
+				return newError(CodeInternal, "Error deleting pending transfer index: %s", err)
+			}
+			continue
+		}
+		err = deletePendingTransfer(stub, row)
+		if err != nil {
+			return err
+		}
+
+		newIP := row.Columns[pendingIPColumn].GetString_()
+		nameRow, transferRow, err := checkTransfer(stub, domainName, row.Columns[pendingRequestColumn].GetString_(), newIP)
+		if err != nil {
+			chaincodeErr := asChaincodeError(err)
+			if chaincodeErr.Code == CodeInternal {
+				return err
+			}
+			emitEvent(stub, RegistryEvent{
+				Type:   EventTransferFailed,
+				Domain: domainName,
+				Buyer:  row.Columns[pendingBuyerColumn].GetString_(),
+				At:     now.Format(timeFormat),
+				Reason: chaincodeErr.Message,
+			})
+			continue
+		}
+		err = applyTransfer(stub, nameRow, transferRow, newIP, now)
+		if err != nil {
+			return err
+		}
+		emitEvent(stub, RegistryEvent{
+			Type:   EventTransferCompleted,
+			Domain: domainName,
+			Owner:  transferRow.Columns[1].GetString_(),
+			Buyer:  transferRow.Columns[2].GetString_(),
+			At:     now.Format(timeFormat),
+		})
+	}
+	return nil
+}
+
+// indexPendingTransfers moves to version 6 by indexing the transfers that
+// were pending before due keys existed
+func indexPendingTransfers(stub *shim.ChaincodeStub) error {
+	// Init creates the table when the chaincode is deployed over older state;
+	// create it here too in case it was not
+	createPendingTransfersTable(stub)
+	rows, err := readAllRows(stub, pendingTransfersTable)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		err = indexPendingTransfer(stub, row)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dueTransfers returns the due keys of the pending transfers that complete
+// at or before now, in order of completion time. The domain follows the
+// 20 digit time and its separator.
+func dueTransfers(stub *shim.ChaincodeStub, now time.Time) ([]string, error) {
+	// the end key is inclusive, ';' sorts right after the ':' separator
+	iter, err := stub.RangeQueryState(pendingDuePrefix, fmt.Sprintf("%s%020d;", pendingDuePrefix, now.UnixNano()))
+	if err != nil {
+		return nil, newError(CodeInternal, "Error reading pending transfers: %s", err)
+	}
+	defer iter.Close()
+	var keys []string
+	for iter.HasNext() {
+		key, _, err := iter.Next()
+		if err != nil {
+			return nil, newError(CodeInternal, "Error reading pending transfers: %s", err)
+		}
+		keys = append(keys, key)
+	}
+	// the iterator returns the keys in no particular order
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// cancelTransfer backs out of a pending transfer. The bid is rejected.
+//
+// args: account, signature, domainName
+func (t *DNSChaincode) cancelTransfer(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	domainName := args[2]
+	_, err := getOwnedDomainRow(stub, args[0], domainName)
+	if err != nil {
+		return nil, err
+	}
+	row, err := getPendingTransfer(stub, domainName)
+	if err != nil {
+		return nil, err
+	}
+	if len(row.Columns) == 0 {
+		return nil, newError(CodeNotFound, "No transfer of %s is pending", domainName)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	err = deletePendingTransfer(stub, row)
+	if err != nil {
+		return nil, err
+	}
+	transferRow, err := stub.GetRow("TransferRequests", stringKey(row.Columns[pendingRequestColumn].GetString_()))
+	if err != nil {
+		return nil, newError(CodeInternal, "Error reading transfer request: %s", err)
+	}
+	if len(transferRow.Columns) != 0 && transferRow.Columns[4].GetString_() == bidStatusOpen {
+		err = rejectRequest(stub, transferRow, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+	emitEvent(stub, RegistryEvent{
+		Type:   EventTransferCancelled,
+		Domain: domainName,
+		Owner:  args[0],
+		Buyer:  row.Columns[pendingBuyerColumn].GetString_(),
+		At:     now.Format(timeFormat),
+	})
+	return nil, nil
+}
+
+// setTransferWindow sets the number of days transfers stay pending. Transfers
+// already pending keep their window.
+//
+// args: account, signature, days
+func (t *DNSChaincode) setTransferWindow(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	days, err := strconv.Atoi(args[2])
+	if err != nil {
+		return nil, newError(CodeInvalidArgument, "Invalid number of days %s", args[2])
+	}
+	err = stub.PutState(transferWindowKey, []byte(strconv.Itoa(days)))
+	if err != nil {
+		return nil, newError(CodeInternal, "Error writing transfer window: %s", err)
+	}
+	return nil, nil
+}
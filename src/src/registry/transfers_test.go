@@ -0,0 +1,100 @@
+/*
+Copyright IBM Corp 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTransferWindow(t *testing.T) {
+	r := newAuctionRegistry(t)
+	if code := r.invoke("setTransferWindow", "alice", "5"); code != CodeUnauthorized {
+		t.Fatalf("Window set by an account: expected %s, got %q", CodeUnauthorized, code)
+	}
+	r.mustInvoke("setTransferWindow", testAdmin, "5")
+	r.mustInvoke("registerDomain", "alice", "example.com", "10.0.0.1", "365")
+	r.mustInvoke("placeBid", "bob", "alice", "example.com", "10")
+	started := r.now
+	r.mustInvoke("transferDomain", "alice", "example.com", "bob", "10.0.0.2")
+
+	domain := r.domain("example.com")
+	if domain.Owner != "alice" || domain.TransferTo != "bob" ||
+		domain.TransferAt != started.AddDate(0, 0, 5).Format(timeFormat) {
+		t.Fatalf("Unexpected pending transfer: %+v", domain)
+	}
+	if code := r.invoke("updateDomain", "alice", "example.com", "10.0.0.3"); code != CodeConflict {
+		t.Fatalf("Update while pending: expected %s, got %q", CodeConflict, code)
+	}
+	if code := r.invoke("deleteDomain", "alice", "example.com"); code != CodeConflict {
+		t.Fatalf("Delete while pending: expected %s, got %q", CodeConflict, code)
+	}
+	if code := r.invoke("transferDomain", "alice", "example.com", "bob", "10.0.0.2"); code != CodeConflict {
+		t.Fatalf("Transfer while pending: expected %s, got %q", CodeConflict, code)
+	}
+	if code := r.invoke("closeAccount", "alice"); code != CodeConflict {
+		t.Fatalf("Closing the seller of a pending transfer: expected %s, got %q", CodeConflict, code)
+	}
+	if code := r.invoke("closeAccount", "bob"); code != CodeConflict {
+		t.Fatalf("Closing the buyer of a pending transfer: expected %s, got %q", CodeConflict, code)
+	}
+
+	// any invoke after the window completes the transfer
+	r.now = started.AddDate(0, 0, 5).Add(-time.Second)
+	r.mustInvoke("registerDomain", "alice", "example.org", "10.0.0.4", "365")
+	if domain = r.domain("example.com"); domain.Owner != "alice" {
+		t.Fatalf("Transfer completed early: %+v", domain)
+	}
+	r.now = started.AddDate(0, 0, 5)
+	r.mustInvoke("registerDomain", "alice", "example.net", "10.0.0.5", "365")
+	domain = r.domain("example.com")
+	if domain.Owner != "bob" || domain.IPAddress != "10.0.0.2" || domain.TransferTo != "" {
+		t.Fatalf("Unexpected completed transfer: %+v", domain)
+	}
+	if code := r.invoke("cancelTransfer", "bob", "example.com"); code != CodeNotFound {
+		t.Fatalf("Cancelling a completed transfer: expected %s, got %q", CodeNotFound, code)
+	}
+}
+
+func TestCancelTransfer(t *testing.T) {
+	r := newAuctionRegistry(t)
+	r.mustInvoke("setTransferWindow", testAdmin, "5")
+	r.mustInvoke("registerDomain", "alice", "example.com", "10.0.0.1", "365")
+	r.mustInvoke("placeBid", "bob", "alice", "example.com", "10")
+	r.mustInvoke("transferDomain", "alice", "example.com", "bob", "10.0.0.2")
+
+	if code := r.invoke("cancelTransfer", "bob", "example.com"); code != CodeUnauthorized {
+		t.Fatalf("Cancelled by the buyer: expected %s, got %q", CodeUnauthorized, code)
+	}
+	r.mustInvoke("cancelTransfer", "alice", "example.com")
+	if domain := r.domain("example.com"); domain.TransferTo != "" {
+		t.Fatalf("Transfer still pending: %+v", domain)
+	}
+	if code := r.invoke("cancelTransfer", "alice", "example.com"); code != CodeNotFound {
+		t.Fatalf("Cancelling twice: expected %s, got %q", CodeNotFound, code)
+	}
+
+	// the bid was rejected, so nothing completes once the window has passed
+	// and the buyer is free to leave
+	r.now = r.now.AddDate(0, 0, 6)
+	r.mustInvoke("updateDomain", "alice", "example.com", "10.0.0.3")
+	if domain := r.domain("example.com"); domain.Owner != "alice" || domain.IPAddress != "10.0.0.3" {
+		t.Fatalf("Unexpected domain after cancelling: %+v", domain)
+	}
+	r.mustInvoke("withdraw", "bob", "1000")
+	r.mustInvoke("closeAccount", "bob")
+}